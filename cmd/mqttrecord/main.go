@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/tq-systems/public-go-utils/v3/log"
+	"github.com/tq-systems/public-go-utils/v3/mqtt"
+)
+
+var (
+	host     = flag.String("host", "localhost", "address of the MQTT broker")
+	port     = flag.Int("port", 1883, "port of the MQTT broker")
+	clientID = flag.String("id", "mqttrecord", "MQTT client ID")
+	qos      = flag.Uint("qos", 2, "maximum QoS of recorded messages")
+	speed    = flag.Float64("speed", 1, "replay speed factor, 0 to replay without delays")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage:
+  %[1]s [flags] record FILE FILTER...
+	records the messages of the topic filters to FILE until interrupted
+  %[1]s [flags] replay FILE
+	publishes the messages recorded in FILE with their original timing
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// run ensures that deferred calls are also executed in case of an error
+func run() error {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 || (args[0] == "record" && len(args) < 3) || (args[0] != "record" && args[0] != "replay") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *qos > 2 {
+		return fmt.Errorf("invalid QoS %d", *qos)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := mqtt.NewClientContext(ctx, *host, *port, *clientID)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %v", err)
+	}
+	defer client.Close()
+
+	if args[0] == "record" {
+		return record(ctx, client, args[1], args[2:])
+	}
+	return replay(ctx, client, args[1])
+}
+
+func record(ctx context.Context, client mqtt.Client, fileName string, filters []string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to create recording: %v", err)
+	}
+
+	log.Infof("Recording %v to %s", filters, fileName)
+	err = mqtt.Record(ctx, client, f, filters, mqtt.WithQoS(byte(*qos)))
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write recording: %v", closeErr)
+	}
+	return err
+}
+
+func replay(ctx context.Context, client mqtt.Client, fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to open recording: %v", err)
+	}
+	defer f.Close()
+
+	log.Infof("Replaying %s", fileName)
+	err = mqtt.Replay(ctx, client, f, *speed)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func main() {
+	err := run()
+	if err != nil {
+		log.Errorf("Failed to run mqttrecord: %v", err)
+		os.Exit(1)
+	}
+}
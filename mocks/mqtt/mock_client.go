@@ -10,6 +10,7 @@
 package mqtt
 
 import (
+	context "context"
 	reflect "reflect"
 
 	mqtt "github.com/tq-systems/public-go-utils/v3/mqtt"
@@ -40,6 +41,20 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 	return m.recorder
 }
 
+// ClearRetained mocks base method.
+func (m *MockClient) ClearRetained(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClearRetained", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClearRetained indicates an expected call of ClearRetained.
+func (mr *MockClientMockRecorder) ClearRetained(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearRetained", reflect.TypeOf((*MockClient)(nil).ClearRetained), arg0, arg1)
+}
+
 // Close mocks base method.
 func (m *MockClient) Close() {
 	m.ctrl.T.Helper()
@@ -52,6 +67,78 @@ func (mr *MockClientMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockClient)(nil).Close))
 }
 
+// ConnectionState mocks base method.
+func (m *MockClient) ConnectionState() mqtt.ConnectionState {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConnectionState")
+	ret0, _ := ret[0].(mqtt.ConnectionState)
+	return ret0
+}
+
+// ConnectionState indicates an expected call of ConnectionState.
+func (mr *MockClientMockRecorder) ConnectionState() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionState", reflect.TypeOf((*MockClient)(nil).ConnectionState))
+}
+
+// GetRetained mocks base method.
+func (m *MockClient) GetRetained(arg0 context.Context, arg1 string) (*mqtt.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRetained", arg0, arg1)
+	ret0, _ := ret[0].(*mqtt.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRetained indicates an expected call of GetRetained.
+func (mr *MockClientMockRecorder) GetRetained(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRetained", reflect.TypeOf((*MockClient)(nil).GetRetained), arg0, arg1)
+}
+
+// GetRetainedPattern mocks base method.
+func (m *MockClient) GetRetainedPattern(arg0 context.Context, arg1 string) ([]*mqtt.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRetainedPattern", arg0, arg1)
+	ret0, _ := ret[0].([]*mqtt.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRetainedPattern indicates an expected call of GetRetainedPattern.
+func (mr *MockClientMockRecorder) GetRetainedPattern(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRetainedPattern", reflect.TypeOf((*MockClient)(nil).GetRetainedPattern), arg0, arg1)
+}
+
+// IsConnected mocks base method.
+func (m *MockClient) IsConnected() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsConnected")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsConnected indicates an expected call of IsConnected.
+func (mr *MockClientMockRecorder) IsConnected() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsConnected", reflect.TypeOf((*MockClient)(nil).IsConnected))
+}
+
+// OnConnectionChange mocks base method.
+func (m *MockClient) OnConnectionChange(arg0 mqtt.ConnectionCallback) func() {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OnConnectionChange", arg0)
+	ret0, _ := ret[0].(func())
+	return ret0
+}
+
+// OnConnectionChange indicates an expected call of OnConnectionChange.
+func (mr *MockClientMockRecorder) OnConnectionChange(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnConnectionChange", reflect.TypeOf((*MockClient)(nil).OnConnectionChange), arg0)
+}
+
 // Publish mocks base method.
 func (m *MockClient) Publish(arg0 string, arg1 byte, arg2 bool, arg3 protoreflect.ProtoMessage) error {
 	m.ctrl.T.Helper()
@@ -66,6 +153,20 @@ func (mr *MockClientMockRecorder) Publish(arg0, arg1, arg2, arg3 any) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Publish", reflect.TypeOf((*MockClient)(nil).Publish), arg0, arg1, arg2, arg3)
 }
 
+// PublishContext mocks base method.
+func (m *MockClient) PublishContext(arg0 context.Context, arg1 string, arg2 byte, arg3 bool, arg4 protoreflect.ProtoMessage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PublishContext", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PublishContext indicates an expected call of PublishContext.
+func (mr *MockClientMockRecorder) PublishContext(arg0, arg1, arg2, arg3, arg4 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublishContext", reflect.TypeOf((*MockClient)(nil).PublishContext), arg0, arg1, arg2, arg3, arg4)
+}
+
 // PublishEmpty mocks base method.
 func (m *MockClient) PublishEmpty(arg0 string, arg1 byte, arg2 bool) error {
 	m.ctrl.T.Helper()
@@ -94,17 +195,119 @@ func (mr *MockClientMockRecorder) PublishRaw(arg0, arg1, arg2, arg3 any) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublishRaw", reflect.TypeOf((*MockClient)(nil).PublishRaw), arg0, arg1, arg2, arg3)
 }
 
+// PublishRawContext mocks base method.
+func (m *MockClient) PublishRawContext(arg0 context.Context, arg1 string, arg2 byte, arg3 bool, arg4 []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PublishRawContext", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PublishRawContext indicates an expected call of PublishRawContext.
+func (mr *MockClientMockRecorder) PublishRawContext(arg0, arg1, arg2, arg3, arg4 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublishRawContext", reflect.TypeOf((*MockClient)(nil).PublishRawContext), arg0, arg1, arg2, arg3, arg4)
+}
+
+// PublishWithProperties mocks base method.
+func (m *MockClient) PublishWithProperties(arg0 context.Context, arg1 string, arg2 byte, arg3 bool, arg4 []byte, arg5 *mqtt.Properties) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PublishWithProperties", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PublishWithProperties indicates an expected call of PublishWithProperties.
+func (mr *MockClientMockRecorder) PublishWithProperties(arg0, arg1, arg2, arg3, arg4, arg5 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublishWithProperties", reflect.TypeOf((*MockClient)(nil).PublishWithProperties), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
 // Subscribe mocks base method.
-func (m *MockClient) Subscribe(arg0 string, arg1 mqtt.Callback) (mqtt.Subscription, error) {
+func (m *MockClient) Subscribe(arg0 string, arg1 mqtt.Callback, arg2 ...mqtt.SubscribeOption) (mqtt.Subscription, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Subscribe", arg0, arg1)
+	varargs := []any{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Subscribe", varargs...)
 	ret0, _ := ret[0].(mqtt.Subscription)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Subscribe indicates an expected call of Subscribe.
-func (mr *MockClientMockRecorder) Subscribe(arg0, arg1 any) *gomock.Call {
+func (mr *MockClientMockRecorder) Subscribe(arg0, arg1 any, arg2 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockClient)(nil).Subscribe), varargs...)
+}
+
+// SubscribeContext mocks base method.
+func (m *MockClient) SubscribeContext(arg0 context.Context, arg1 string, arg2 mqtt.Callback, arg3 ...mqtt.SubscribeOption) (mqtt.Subscription, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SubscribeContext", varargs...)
+	ret0, _ := ret[0].(mqtt.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SubscribeContext indicates an expected call of SubscribeContext.
+func (mr *MockClientMockRecorder) SubscribeContext(arg0, arg1, arg2 any, arg3 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubscribeContext", reflect.TypeOf((*MockClient)(nil).SubscribeContext), varargs...)
+}
+
+// SubscribeMany mocks base method.
+func (m *MockClient) SubscribeMany(arg0 context.Context, arg1 []mqtt.SubscriptionRequest) ([]mqtt.Subscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubscribeMany", arg0, arg1)
+	ret0, _ := ret[0].([]mqtt.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SubscribeMany indicates an expected call of SubscribeMany.
+func (mr *MockClientMockRecorder) SubscribeMany(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubscribeMany", reflect.TypeOf((*MockClient)(nil).SubscribeMany), arg0, arg1)
+}
+
+// SubscribeMessage mocks base method.
+func (m *MockClient) SubscribeMessage(arg0 context.Context, arg1 string, arg2 mqtt.MessageCallback, arg3 ...mqtt.SubscribeOption) (mqtt.Subscription, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SubscribeMessage", varargs...)
+	ret0, _ := ret[0].(mqtt.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SubscribeMessage indicates an expected call of SubscribeMessage.
+func (mr *MockClientMockRecorder) SubscribeMessage(arg0, arg1, arg2 any, arg3 ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubscribeMessage", reflect.TypeOf((*MockClient)(nil).SubscribeMessage), varargs...)
+}
+
+// UnsubscribeMany mocks base method.
+func (m *MockClient) UnsubscribeMany(arg0 context.Context, arg1 []mqtt.Subscription) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnsubscribeMany", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnsubscribeMany indicates an expected call of UnsubscribeMany.
+func (mr *MockClientMockRecorder) UnsubscribeMany(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockClient)(nil).Subscribe), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnsubscribeMany", reflect.TypeOf((*MockClient)(nil).UnsubscribeMany), arg0, arg1)
 }
@@ -10,6 +10,7 @@
 package mqtt
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "go.uber.org/mock/gomock"
@@ -38,6 +39,20 @@ func (m *MockSubscription) EXPECT() *MockSubscriptionMockRecorder {
 	return m.recorder
 }
 
+// Dropped mocks base method.
+func (m *MockSubscription) Dropped() uint64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Dropped")
+	ret0, _ := ret[0].(uint64)
+	return ret0
+}
+
+// Dropped indicates an expected call of Dropped.
+func (mr *MockSubscriptionMockRecorder) Dropped() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dropped", reflect.TypeOf((*MockSubscription)(nil).Dropped))
+}
+
 // Unsubscribe mocks base method.
 func (m *MockSubscription) Unsubscribe() {
 	m.ctrl.T.Helper()
@@ -49,3 +64,17 @@ func (mr *MockSubscriptionMockRecorder) Unsubscribe() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unsubscribe", reflect.TypeOf((*MockSubscription)(nil).Unsubscribe))
 }
+
+// UnsubscribeContext mocks base method.
+func (m *MockSubscription) UnsubscribeContext(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnsubscribeContext", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnsubscribeContext indicates an expected call of UnsubscribeContext.
+func (mr *MockSubscriptionMockRecorder) UnsubscribeContext(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnsubscribeContext", reflect.TypeOf((*MockSubscription)(nil).UnsubscribeContext), arg0)
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+/* A backend implements the network connection of a client. Two backends exist:
+ * libmosquitto (mosquitto.go, requires cgo) and a pure-Go implementation of
+ * MQTT 3.1.1 (gobackend.go).
+ *
+ * Backends report events to their client by calling onConnect, onDisconnect,
+ * onPubSub and onMessage from a thread or goroutine of their own. The message
+ * IDs returned by subscribe and publish are passed to onPubSub as soon as the
+ * broker confirmed the action. onDisconnect receives a reason code (see
+ * DisconnectRequested) and an error describing it. Once connect succeeded, a backend reconnects
+ * automatically whenever the connection is lost, until disconnect is called.
+ */
+type backend interface {
+	connect() error
+	// subscribe and unsubscribe act on all topics with a single request. id is the MQTT v5
+	// subscription identifier of the topics, 0 for none.
+	subscribe(topics []string, opts SubscribeOptions, id int) (int, error)
+	unsubscribe(topics []string) (int, error)
+	publish(topic string, qos byte, retain bool, payload []byte, properties *Properties) (int, error)
+	// disconnect starts closing the connection; onDisconnect is called when it is closed.
+	// It must not call into the client synchronously.
+	disconnect()
+	// stop releases all resources of the backend after disconnect
+	stop()
+}
+
+// A newBackendFunc creates a backend for client, configured by opts
+type newBackendFunc func(client *client, brokerAddress string, brokerPort int, clientID string,
+	opts *options) (backend, error)
+
+// newDefaultBackend is used unless WithPureGo is given. It is replaced by the
+// libmosquitto backend if the package is built with cgo.
+var newDefaultBackend newBackendFunc = newGoBackend
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"errors"
+)
+
+// A SubscriptionRequest describes one of the subscriptions made by SubscribeMany
+type SubscriptionRequest struct {
+	Topic    string
+	Callback MessageCallback
+	Options  []SubscribeOption
+}
+
+// A topicGroup holds topics that are subscribed to with the same options
+type topicGroup struct {
+	opts SubscribeOptions
+	// id is the subscription identifier of the topics
+	id     int
+	topics []string
+
+	// The request made by subscribe for the group
+	result *subscribeResult
+}
+
+// groupTopic adds topic to the group of opts and id, appending a new group if there is none yet
+func groupTopic(groups []*topicGroup, topic string, opts SubscribeOptions, id int) []*topicGroup {
+	for _, group := range groups {
+		if group.opts == opts && group.id == id {
+			group.topics = append(group.topics, topic)
+			return groups
+		}
+	}
+	return append(groups, &topicGroup{opts: opts, id: id, topics: []string{topic}})
+}
+
+/* SubscribeMany works like calling SubscribeMessage for every request, but sends a
+ * single request to the broker for all topics with the same subscription options and
+ * waits for their confirmations at once. Either all subscriptions are made or, if one
+ * of them fails, none. The subscriptions are returned in the order of requests.
+ */
+func (client *client) SubscribeMany(ctx context.Context, requests []SubscriptionRequest) ([]Subscription, error) {
+	subRequests := make([]subscriptionRequest, len(requests))
+	for i, request := range requests {
+		if request.Callback == nil {
+			return nil, errors.New("error during Subscription: empty topic or nil callback not allowed")
+		}
+		opts, err := NewSubscribeOptions(request.Options...)
+		if err != nil {
+			return nil, err
+		}
+		if opts.isV5() && !client.protocolV5 {
+			return nil, ErrProtocolV5Required
+		}
+		subRequests[i] = subscriptionRequest{topic: request.Topic, callback: request.Callback, opts: opts}
+	}
+
+	return client.subscribe(ctx, subRequests)
+}
+
+/* UnsubscribeMany removes all subscriptions, sending a single request to the broker for
+ * the topics without remaining subscriptions. It waits for the broker to confirm it
+ * until ctx is done; without a deadline on ctx, brokerConfirmTimeout applies.
+ * Subscriptions removed before are skipped, subscriptions of other clients are
+ * unsubscribed one by one.
+ */
+func (client *client) UnsubscribeMany(ctx context.Context, subscriptions []Subscription) error {
+	own := make([]*subscription, 0, len(subscriptions))
+	for _, s := range subscriptions {
+		sub, ok := s.(*subscription)
+		if !ok || sub.client != client {
+			s.Unsubscribe()
+			continue
+		}
+		sub.stop()
+		own = append(own, sub)
+	}
+
+	var err error
+	var mid int
+	var confirmed chan error
+	locked(client.currentMsgLock, func() {
+		topics := make([]string, 0)
+		locked(client.lock, func() {
+			if client.closing {
+				err = ErrClientClosed
+				return
+			}
+			for _, sub := range own {
+				if client.removeSubscription(sub) && client.releaseTopic(sub.topic) {
+					topics = append(topics, sub.topic)
+				}
+			}
+		})
+
+		if err == nil && len(topics) > 0 {
+			mid, confirmed, err = client.sendUnsubscribe(topics, true)
+		}
+	})
+
+	if err != nil || confirmed == nil {
+		return err
+	}
+	return client.waitForConfirm(ctx, mid, confirmed)
+}
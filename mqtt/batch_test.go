@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchSubscriptions(t *testing.T) {
+	broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+	c, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	received := make(chan string, 10)
+	receive := func(message *Message) {
+		received <- message.Topic
+	}
+	requests := []SubscriptionRequest{
+		{Topic: "a", Callback: receive},
+		{Topic: "b/+", Callback: receive},
+		{Topic: "c", Callback: receive, Options: []SubscribeOption{WithQoS(0)}},
+	}
+
+	t.Run("Subscribe", func(t *testing.T) {
+		subs, err := c.SubscribeMany(context.Background(), requests)
+		assert.Nil(t, err)
+		assert.Len(t, subs, 3)
+		// One request per set of options
+		assert.Equal(t, 2, broker.requestCount())
+		assert.Equal(t, []string{"a", "b/+", "c"}, broker.subscribed())
+		assert.Equal(t, []byte{2, 2, 0}, broker.subscribedQoSLevels())
+
+		assert.Nil(t, c.PublishRaw("b/1", 1, false, []byte{}))
+		expectMessage(t, received, "b/1")
+
+		assert.Nil(t, c.UnsubscribeMany(context.Background(), subs))
+		assert.Equal(t, 3, broker.requestCount())
+		assert.Equal(t, []string{"a", "b/+", "c"}, broker.unsubscribedTopics())
+	})
+
+	t.Run("Resubscribe", func(t *testing.T) {
+		subs, err := c.SubscribeMany(context.Background(), requests)
+		assert.Nil(t, err)
+		requested := broker.requestCount()
+
+		broker.dropConnections()
+		assert.Eventually(t, func() bool {
+			return broker.requestCount() == requested+2
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.ElementsMatch(t, []string{"a", "b/+", "c"}, broker.subscribed()[6:])
+
+		assert.Nil(t, c.UnsubscribeMany(context.Background(), subs))
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		_, err := c.SubscribeMany(context.Background(), []SubscriptionRequest{
+			{Topic: "d", Callback: receive},
+			{Topic: "e/#/f", Callback: receive},
+		})
+		assert.ErrorContains(t, err, "invalid topic filter")
+
+		// Subscriptions made before the failure are removed as well
+		client := c.(*client)
+		locked(client.lock, func() {
+			assert.Empty(t, client.subscriptions)
+			assert.Empty(t, client.subscribedTopics)
+		})
+
+		_, err = c.SubscribeMany(context.Background(), []SubscriptionRequest{{Topic: "d"}})
+		assert.Error(t, err)
+		_, err = c.SubscribeMany(context.Background(), []SubscriptionRequest{
+			{Topic: "d", Callback: receive, Options: []SubscribeOption{WithNoLocal()}},
+		})
+		assert.ErrorIs(t, err, ErrProtocolV5Required)
+	})
+}
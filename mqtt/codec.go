@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"sync"
+
+	"github.com/tq-systems/public-go-utils/v3/log"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+/* A Codec converts values to message payloads and back, see PublishEncoded and
+ * SubscribeDecoded. Apps can add codecs for further formats, e.g. CBOR, with
+ * RegisterCodec. Codecs must be safe for concurrent use.
+ */
+type Codec interface {
+	// ContentType is the MIME type of the payloads, sent as content type with MQTT v5
+	ContentType() string
+	Marshal(value any) ([]byte, error)
+	Unmarshal(data []byte, value any) error
+}
+
+var (
+	// ProtoCodec encodes protobuf messages in the binary format, using vtprotobuf if available
+	ProtoCodec Codec = protoCodec{}
+	// ProtoJSONCodec encodes protobuf messages in the canonical JSON format
+	ProtoJSONCodec Codec = protoJSONCodec{}
+	// JSONCodec encodes values with encoding/json and protobuf messages like ProtoJSONCodec
+	JSONCodec Codec = jsonCodec{}
+)
+
+var (
+	codecsLock sync.RWMutex
+	// codecs holds the registered codecs by content type
+	codecs = map[string]Codec{
+		ProtoCodec.ContentType(): ProtoCodec,
+		JSONCodec.ContentType():  JSONCodec,
+	}
+)
+
+// errNotProto is returned by the protobuf codecs for other values
+var errNotProto = errors.New("value is not a protobuf message")
+
+type protoCodec struct{}
+
+func (protoCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protoCodec) Marshal(value any) ([]byte, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return nil, errNotProto
+	}
+	return marshalProto(message)
+}
+
+func (protoCodec) Unmarshal(data []byte, value any) error {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return errNotProto
+	}
+	return unmarshalProto(data, message)
+}
+
+type protoJSONCodec struct{}
+
+func (protoJSONCodec) ContentType() string { return "application/json" }
+
+func (protoJSONCodec) Marshal(value any) ([]byte, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return nil, errNotProto
+	}
+	return protojson.Marshal(message)
+}
+
+func (protoJSONCodec) Unmarshal(data []byte, value any) error {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return errNotProto
+	}
+	return protojson.Unmarshal(data, message)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(value any) ([]byte, error) {
+	if message, ok := value.(proto.Message); ok {
+		return protojson.Marshal(message)
+	}
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, value any) error {
+	if message, ok := value.(proto.Message); ok {
+		return protojson.Unmarshal(data, message)
+	}
+	return json.Unmarshal(data, value)
+}
+
+/* RegisterCodec makes codec available to SubscribeDecoded for messages with its content
+ * type, replacing a codec registered for the same content type before. ProtoCodec and
+ * JSONCodec are registered by default.
+ */
+func RegisterCodec(codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[codec.ContentType()] = codec
+}
+
+// CodecForContentType returns the codec registered for contentType, ignoring its parameters
+func CodecForContentType(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	codec, ok := codecs[mediaType]
+	return codec, ok
+}
+
+/* PublishEncoded encodes value with codec and publishes it like PublishRawContext. If the
+ * connection uses MQTT v5, the content type of codec is sent along with the message.
+ */
+func PublishEncoded(ctx context.Context, client Client, codec Codec, topic string, qos byte, retain bool,
+	value any) error {
+	payload, err := codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("unable to encode message for topic %s: %w", topic, err)
+	}
+
+	properties := &Properties{ContentType: codec.ContentType()}
+	err = client.PublishWithProperties(ctx, topic, qos, retain, payload, properties)
+	if errors.Is(err, ErrProtocolV5Required) {
+		return client.PublishRawContext(ctx, topic, qos, retain, payload)
+	}
+	return err
+}
+
+/* SubscribeDecoded subscribes to topic like Client.SubscribeMessage, but decodes every
+ * message into a new T with codec before running callback. If codec is nil, the codec is
+ * chosen by the content type of each message (see RegisterCodec); messages without
+ * content type are decoded with ProtoCodec, like the payloads of Client.Publish.
+ * Messages that cannot be decoded are reported to errCallback instead; if errCallback is
+ * nil, they are logged.
+ *
+ *	mqtt.SubscribeDecoded(client, topic, mqtt.JSONCodec, func(topic string, status *Status) {
+ *		...
+ *	}, nil)
+ */
+func SubscribeDecoded[T any](client Client, topic string, codec Codec, callback func(topic string, value *T),
+	errCallback func(topic string, err error), opts ...SubscribeOption) (Subscription, error) {
+	if callback == nil {
+		return nil, errors.New("error during Subscription: nil callback not allowed")
+	}
+
+	return client.SubscribeMessage(context.Background(), topic, func(message *Message) {
+		value := new(T)
+		if err := decodeMessage(codec, message, value); err != nil {
+			err = fmt.Errorf("unable to decode %T: %w", value, err)
+			if errCallback != nil {
+				errCallback(message.Topic, err)
+			} else {
+				log.Errorf("Dropping message on topic %s: %v", message.Topic, err)
+			}
+			return
+		}
+		callback(message.Topic, value)
+	}, opts...)
+}
+
+// decodeMessage decodes the payload of message into value with codec or, if codec is nil,
+// with the codec of the content type of message
+func decodeMessage(codec Codec, message *Message, value any) error {
+	if codec == nil {
+		codec = ProtoCodec
+		if message.Properties != nil && message.Properties.ContentType != "" {
+			var ok bool
+			codec, ok = CodecForContentType(message.Properties.ContentType)
+			if !ok {
+				return fmt.Errorf("no codec for content type %s", message.Properties.ContentType)
+			}
+		}
+	}
+	return codec.Unmarshal(message.Payload, value)
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	mock_mqtt "github.com/tq-systems/public-go-utils/v3/mocks/mqtt"
+	"github.com/tq-systems/public-go-utils/v3/mqtt"
+	"github.com/tq-systems/public-go-utils/v3/mqtt/mqtttest"
+	"github.com/tq-systems/public-go-utils/v3/mqtt/test"
+)
+
+type status struct {
+	State string `json:"state"`
+}
+
+// textCodec stands in for a codec registered by an app
+type textCodec struct{}
+
+func (textCodec) ContentType() string { return "text/plain" }
+
+func (textCodec) Marshal(value any) ([]byte, error) {
+	return []byte(value.(*status).State), nil
+}
+
+func (textCodec) Unmarshal(data []byte, value any) error {
+	value.(*status).State = string(data)
+	return nil
+}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []mqtt.Codec{mqtt.ProtoCodec, mqtt.ProtoJSONCodec, mqtt.JSONCodec} {
+		data, err := codec.Marshal(&test.Test{MessageCounter: 42})
+		assert.NoError(t, err)
+		decoded := &test.Test{}
+		assert.NoError(t, codec.Unmarshal(data, decoded))
+		assert.Equal(t, uint64(42), decoded.MessageCounter)
+	}
+
+	data, err := mqtt.ProtoJSONCodec.Marshal(&test.Test{MessageCounter: 42})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"messageCounter":"42"}`, string(data))
+	data, err = mqtt.JSONCodec.Marshal(&status{State: "ok"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"state":"ok"}`, string(data))
+
+	_, err = mqtt.ProtoCodec.Marshal(&status{})
+	assert.Error(t, err)
+	assert.Error(t, mqtt.ProtoJSONCodec.Unmarshal([]byte("{}"), &status{}))
+
+	codec, ok := mqtt.CodecForContentType("application/json; charset=utf-8")
+	assert.True(t, ok)
+	assert.Equal(t, mqtt.JSONCodec, codec)
+	_, ok = mqtt.CodecForContentType("image/png")
+	assert.False(t, ok)
+}
+
+func TestPublishEncoded(t *testing.T) {
+	broker := mqtttest.NewBroker()
+	client := broker.NewClient("client")
+	defer client.Close()
+
+	// The codec is chosen by the content type of every message
+	mqtt.RegisterCodec(textCodec{})
+	received := make([]string, 0)
+	errs := make([]error, 0)
+	_, err := mqtt.SubscribeDecoded(client, "status/+", nil, func(topic string, value *status) {
+		received = append(received, value.State)
+	}, func(topic string, err error) {
+		errs = append(errs, err)
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, mqtt.PublishEncoded(context.Background(), client, mqtt.JSONCodec, "status/a", 1, false,
+		&status{State: "json"}))
+	assert.NoError(t, mqtt.PublishEncoded(context.Background(), client, textCodec{}, "status/b", 1, false,
+		&status{State: "text"}))
+	assert.NoError(t, client.PublishWithProperties(context.Background(), "status/c", 1, false, []byte("?"),
+		&mqtt.Properties{ContentType: "image/png"}))
+	assert.NoError(t, client.PublishRaw("status/d", 1, false, []byte("{")))
+	assert.Equal(t, []string{"json", "text"}, received)
+	if assert.Len(t, errs, 2) {
+		assert.ErrorContains(t, errs[0], "no codec for content type image/png")
+		assert.ErrorContains(t, errs[1], "value is not a protobuf message")
+	}
+
+	// With a codec given, the content type is ignored
+	var message *mqtt.Message
+	_, err = client.SubscribeMessage(context.Background(), "counter", func(m *mqtt.Message) {
+		message = m
+	})
+	assert.NoError(t, err)
+	counters := make([]uint64, 0)
+	_, err = mqtt.SubscribeDecoded(client, "counter", mqtt.ProtoJSONCodec, func(topic string, value *test.Test) {
+		counters = append(counters, value.MessageCounter)
+	}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, mqtt.PublishEncoded(context.Background(), client, mqtt.JSONCodec, "counter", 1, false,
+		&test.Test{MessageCounter: 7}))
+	assert.Equal(t, []uint64{7}, counters)
+	assert.Equal(t, "application/json", message.Properties.ContentType)
+
+	_, err = mqtt.SubscribeDecoded[status](client, "status/+", nil, nil, nil)
+	assert.Error(t, err)
+	assert.Error(t, mqtt.PublishEncoded(context.Background(), client, mqtt.ProtoCodec, "status/a", 1, false,
+		&status{}))
+}
+
+func TestPublishEncodedWithoutV5(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := mock_mqtt.NewMockClient(ctrl)
+	payload := []byte{0x08, 0x01}
+
+	gomock.InOrder(
+		client.EXPECT().PublishWithProperties(gomock.Any(), "counter", byte(1), true, payload,
+			&mqtt.Properties{ContentType: "application/x-protobuf"}).Return(mqtt.ErrProtocolV5Required),
+		client.EXPECT().PublishRawContext(gomock.Any(), "counter", byte(1), true, payload).Return(nil),
+	)
+	assert.NoError(t, mqtt.PublishEncoded(context.Background(), client, mqtt.ProtoCodec, "counter", 1, true,
+		&test.Test{MessageCounter: 1}))
+
+	errFailed := errors.New("failed")
+	client.EXPECT().PublishWithProperties(gomock.Any(), "counter", byte(1), true, payload, gomock.Any()).
+		Return(errFailed)
+	assert.ErrorIs(t, mqtt.PublishEncoded(context.Background(), client, mqtt.ProtoCodec, "counter", 1, true,
+		&test.Test{MessageCounter: 1}), errFailed)
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// subscribedTopics returns the topics the client holds a broker subscription for
+func subscribedTopics(c Client) []string {
+	client := c.(*client)
+	topics := make([]string, 0)
+	locked(client.lock, func() {
+		for topic := range client.subscribedTopics {
+			topics = append(topics, topic)
+		}
+	})
+	return topics
+}
+
+// syncBroker waits until the broker processed all requests sent by client before
+func syncBroker(t *testing.T, client Client) {
+	assert.Nil(t, client.PublishRaw("sync", 1, false, []byte{}))
+}
+
+// pendingWaiters returns the number of calls waiting for the pending broker subscription of topic
+func pendingWaiters(c Client, topic string) int {
+	client := c.(*client)
+	waiters := 0
+	locked(client.currentMsgLock, func() {
+		locked(client.lock, func() {
+			if topicSub, ok := client.subscribedTopics[topic]; ok && topicSub.pending != nil {
+				waiters = topicSub.pending.waiters
+			}
+		})
+	})
+	return waiters
+}
+
+func TestConcurrentSubscriptions(t *testing.T) {
+	broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+	client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	callback := func(string, []byte) {}
+	topics := []string{"a", "b", "c/+"}
+
+	t.Run("Stress", func(t *testing.T) {
+		var wg sync.WaitGroup
+		// The last subscription of every goroutine is kept
+		kept := make([]Subscription, 8)
+		for i := 0; i < len(kept); i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					topic := topics[(i+j)%len(topics)]
+					sub, err := client.Subscribe(topic, callback)
+					if !assert.Nil(t, err) {
+						return
+					}
+					if j == 99 {
+						kept[i] = sub
+					} else if j%2 == 0 {
+						sub.Unsubscribe()
+					} else {
+						assert.Nil(t, sub.UnsubscribeContext(context.Background()))
+					}
+				}
+			}(i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				requests := make([]SubscriptionRequest, 0)
+				for _, topic := range topics {
+					requests = append(requests, SubscriptionRequest{Topic: topic, Callback: func(*Message) {}})
+				}
+				subs, err := client.SubscribeMany(context.Background(), requests)
+				if !assert.Nil(t, err) {
+					return
+				}
+				assert.Nil(t, client.UnsubscribeMany(context.Background(), subs))
+			}
+		}()
+		wg.Wait()
+
+		syncBroker(t, client)
+		assert.ElementsMatch(t, subscribedTopics(client), broker.activeFilters())
+
+		assert.Nil(t, client.UnsubscribeMany(context.Background(), kept))
+		assert.Empty(t, subscribedTopics(client))
+		assert.Empty(t, broker.activeFilters())
+	})
+
+	t.Run("Last unsubscribe and new subscribe", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			topic := fmt.Sprintf("topic/%d", i)
+			old, err := client.Subscribe(topic, callback)
+			assert.Nil(t, err)
+
+			var wg sync.WaitGroup
+			var sub Subscription
+			start := make(chan struct{})
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				<-start
+				old.Unsubscribe()
+			}()
+			go func() {
+				defer wg.Done()
+				<-start
+				// Varies which of both goroutines changes the subscriptions first
+				time.Sleep(time.Duration(i%5) * 10 * time.Microsecond)
+				var err error
+				sub, err = client.Subscribe(topic, callback)
+				assert.Nil(t, err)
+			}()
+			close(start)
+			wg.Wait()
+
+			// Whatever happened first, the broker must keep the subscription
+			syncBroker(t, client)
+			assert.Equal(t, []string{topic}, broker.activeFilters())
+
+			assert.Nil(t, sub.UnsubscribeContext(context.Background()))
+		}
+	})
+
+	t.Run("Pending broker subscription", func(t *testing.T) {
+		broker.setAckSubscribe(false)
+		defer broker.setAckSubscribe(true)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		first := make(chan error)
+		go func() {
+			_, err := client.SubscribeContext(ctx, "pending", callback)
+			first <- err
+		}()
+		assert.Eventually(t, func() bool {
+			return slices.Contains(subscribedTopics(client), "pending")
+		}, 5*time.Second, time.Millisecond)
+
+		// A second subscription of the topic waits for the same SUBACK, even after the first
+		// one gave up
+		type result struct {
+			sub Subscription
+			err error
+		}
+		second := make(chan result)
+		go func() {
+			sub, err := client.Subscribe("pending", callback)
+			second <- result{sub, err}
+		}()
+		assert.Eventually(t, func() bool {
+			return pendingWaiters(client, "pending") == 2
+		}, 5*time.Second, time.Millisecond)
+		cancel()
+		assert.ErrorIs(t, <-first, context.Canceled)
+
+		broker.setAckSubscribe(true)
+		broker.releaseSubacks()
+		r := <-second
+		assert.Nil(t, r.err)
+		assert.Equal(t, []string{"pending"}, subscribedTopics(client))
+		assert.Equal(t, 1, strings.Count(strings.Join(broker.subscribed(), ","), "pending"))
+
+		assert.Nil(t, r.sub.UnsubscribeContext(context.Background()))
+		assert.Empty(t, subscribedTopics(client))
+	})
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"time"
+)
+
+// Reason codes of a disconnect. They equal the error numbers of libmosquitto, which
+// may report other error numbers as well.
+const (
+	// DisconnectRequested means that the connection was closed by Client.Close
+	DisconnectRequested = 0
+	// DisconnectProtocolError means that the broker sent an invalid packet
+	DisconnectProtocolError = 2
+	// DisconnectConnectionLost means that the network connection was closed or failed
+	DisconnectConnectionLost = 7
+	// DisconnectKeepaliveTimeout means that the broker did not respond within the keepalive interval
+	DisconnectKeepaliveTimeout = 19
+)
+
+// A ConnectionEvent reports that the connection to the broker was established or lost
+type ConnectionEvent struct {
+	Connected bool
+	Time      time.Time
+	// Reason is the reason code of a disconnect, see DisconnectRequested
+	Reason int
+	// Err describes why the connection was lost, it is nil unless Reason is set
+	Err error
+}
+
+// A ConnectionCallback is run for every change of the connection state
+type ConnectionCallback func(event ConnectionEvent)
+
+// ConnectionState describes the connection of a client to the broker
+type ConnectionState struct {
+	Connected bool `json:"connected"`
+	// LastConnect and LastDisconnect are zero if the client never (dis)connected
+	LastConnect    time.Time `json:"lastConnect"`
+	LastDisconnect time.Time `json:"lastDisconnect"`
+	// DisconnectReason and DisconnectError describe the last disconnect
+	DisconnectReason int    `json:"disconnectReason"`
+	DisconnectError  string `json:"disconnectError,omitempty"`
+}
+
+// connectionWatcher holds a ConnectionCallback, registered by its address
+type connectionWatcher struct {
+	callback ConnectionCallback
+}
+
+// IsConnected returns whether the client is connected to the broker
+func (client *client) IsConnected() bool {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return client.connected
+}
+
+// ConnectionState returns the current state of the connection
+func (client *client) ConnectionState() ConnectionState {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return client.state
+}
+
+// OnConnectionChange registers callback to be run whenever the connection is established
+// or lost, until the returned function is called. Like message callbacks, it runs in the
+// network thread and must not block.
+func (client *client) OnConnectionChange(callback ConnectionCallback) func() {
+	watcher := &connectionWatcher{callback: callback}
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.watchers[watcher] = true
+
+	return func() {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		delete(client.watchers, watcher)
+	}
+}
+
+// updateConnectionState records event and returns the callbacks to notify.
+// Must be called with client.lock held.
+func (client *client) updateConnectionState(event ConnectionEvent) []ConnectionCallback {
+	client.connected = event.Connected
+	client.state.Connected = event.Connected
+	if event.Connected {
+		client.state.LastConnect = event.Time
+	} else {
+		client.state.LastDisconnect = event.Time
+		client.state.DisconnectReason = event.Reason
+		client.state.DisconnectError = ""
+		if event.Err != nil {
+			client.state.DisconnectError = event.Err.Error()
+		}
+	}
+
+	callbacks := make([]ConnectionCallback, 0, len(client.watchers))
+	for watcher := range client.watchers {
+		callbacks = append(callbacks, watcher.callback)
+	}
+	return callbacks
+}
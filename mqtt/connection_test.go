@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func expectConnectionEvent(t *testing.T, events chan ConnectionEvent, connected bool, reason int) ConnectionEvent {
+	select {
+	case event := <-events:
+		assert.Equal(t, connected, event.Connected)
+		assert.Equal(t, reason, event.Reason)
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection event (connected: %v) not received", connected)
+		return ConnectionEvent{}
+	}
+}
+
+func TestConnectionState(t *testing.T) {
+	broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+	start := time.Now()
+	client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	assert.True(t, client.IsConnected())
+	state := client.ConnectionState()
+	assert.True(t, state.Connected)
+	assert.False(t, state.LastConnect.Before(start))
+	assert.True(t, state.LastDisconnect.IsZero())
+
+	events := make(chan ConnectionEvent, 10)
+	unregister := client.OnConnectionChange(func(event ConnectionEvent) {
+		events <- event
+	})
+
+	broker.dropConnections()
+	lost := expectConnectionEvent(t, events, false, DisconnectConnectionLost)
+	assert.NotNil(t, lost.Err)
+
+	reconnected := expectConnectionEvent(t, events, true, DisconnectRequested)
+	assert.True(t, client.IsConnected())
+	state = client.ConnectionState()
+	assert.Equal(t, reconnected.Time, state.LastConnect)
+	assert.Equal(t, lost.Time, state.LastDisconnect)
+	assert.Equal(t, DisconnectConnectionLost, state.DisconnectReason)
+	assert.Equal(t, lost.Err.Error(), state.DisconnectError)
+
+	// Unregistered callbacks are not run anymore
+	other := make(chan ConnectionEvent, 10)
+	client.OnConnectionChange(func(event ConnectionEvent) {
+		other <- event
+	})
+	unregister()
+
+	client.Close()
+	closed := expectConnectionEvent(t, other, false, DisconnectRequested)
+	assert.Nil(t, closed.Err)
+	assert.False(t, client.IsConnected())
+	assert.Equal(t, DisconnectRequested, client.ConnectionState().DisconnectReason)
+	assert.Empty(t, client.ConnectionState().DisconnectError)
+	assert.Empty(t, events)
+}
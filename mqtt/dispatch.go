@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// An OverflowPolicy decides what happens to a message delivered to a subscription
+// whose queue is full (see WithAsyncDispatch)
+type OverflowPolicy int
+
+const (
+	// DropOldest removes the oldest queued message to make room for the new one
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the new message
+	DropNewest
+	// Block waits until the subscription's queue has room again. This blocks the
+	// delivery of all further messages of the client, like a slow synchronous callback.
+	Block
+)
+
+func (policy OverflowPolicy) String() string {
+	switch policy {
+	case DropOldest:
+		return "drop oldest"
+	case DropNewest:
+		return "drop newest"
+	case Block:
+		return "block"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(policy))
+	}
+}
+
+type dispatchOptions struct {
+	queueSize int
+	policy    OverflowPolicy
+}
+
+/* A dispatcher delivers the messages of a subscription to its callback. Without
+ * dispatchOptions, the callback runs synchronously in deliver. Otherwise, deliver
+ * only queues the message and the callback runs in a goroutine owned by the
+ * dispatcher, so a slow callback only delays the messages of its own subscription.
+ */
+type dispatcher struct {
+	callback MessageCallback
+	policy   OverflowPolicy
+	queue    chan *Message
+	done     chan struct{}
+	stopOnce sync.Once
+	dropped  atomic.Uint64
+}
+
+func newDispatcher(callback MessageCallback, opts *dispatchOptions) *dispatcher {
+	d := &dispatcher{
+		callback: callback,
+	}
+
+	if opts != nil {
+		d.policy = opts.policy
+		d.queue = make(chan *Message, opts.queueSize)
+		d.done = make(chan struct{})
+		go d.run()
+	}
+
+	return d
+}
+
+func (d *dispatcher) run() {
+	for {
+		select {
+		case msg := <-d.queue:
+			select {
+			case <-d.done:
+				return
+			default:
+			}
+			d.callback(msg)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// deliver runs the callback for a message or queues it according to the overflow policy
+func (d *dispatcher) deliver(msg *Message) {
+	if d.queue == nil {
+		d.callback(msg)
+		return
+	}
+
+	switch d.policy {
+	case Block:
+		select {
+		case d.queue <- msg:
+		case <-d.done:
+		}
+	case DropNewest:
+		select {
+		case d.queue <- msg:
+		default:
+			d.dropped.Add(1)
+		}
+	default:
+		for {
+			select {
+			case d.queue <- msg:
+				return
+			default:
+			}
+			// The queue is full, discard the oldest message unless the
+			// worker took it in the meantime
+			select {
+			case <-d.queue:
+				d.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+// stop terminates the worker goroutine; queued messages are discarded. A callback
+// that is running already is not interrupted.
+func (d *dispatcher) stop() {
+	if d.done == nil {
+		return
+	}
+	d.stopOnce.Do(func() {
+		close(d.done)
+	})
+}
+
+// Dropped returns the number of messages discarded because the queue was full
+func (d *dispatcher) Dropped() uint64 {
+	return d.dropped.Load()
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingCallback returns a callback that records the payloads it receives, but
+// does not return before release is closed
+func blockingCallback(release chan struct{}, mutex *sync.Mutex, received *[]string) MessageCallback {
+	return func(message *Message) {
+		<-release
+		mutex.Lock()
+		defer mutex.Unlock()
+		*received = append(*received, string(message.Payload))
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	t.Run("Synchronous", func(t *testing.T) {
+		received := ""
+		d := newDispatcher(func(message *Message) {
+			received = string(message.Payload)
+		}, nil)
+		defer d.stop()
+
+		d.deliver(&Message{Topic: topic, Payload: []byte("1")})
+		assert.Equal(t, "1", received)
+	})
+
+	policies := []struct {
+		policy   OverflowPolicy
+		expected []string
+		dropped  uint64
+	}{
+		// The worker took message 1 and blocks in the callback, the queue holds two messages
+		{DropOldest, []string{"1", "4", "5"}, 2},
+		{DropNewest, []string{"1", "2", "3"}, 2},
+	}
+
+	for _, tt := range policies {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			release := make(chan struct{})
+			mutex := &sync.Mutex{}
+			received := make([]string, 0)
+
+			d := newDispatcher(blockingCallback(release, mutex, &received),
+				&dispatchOptions{queueSize: 2, policy: tt.policy})
+			defer d.stop()
+
+			d.deliver(&Message{Topic: topic, Payload: []byte("1")})
+			// Wait for the worker to take the first message from the queue
+			assert.Eventually(t, func() bool { return len(d.queue) == 0 }, time.Second, time.Millisecond)
+			for _, msg := range []string{"2", "3", "4", "5"} {
+				d.deliver(&Message{Topic: topic, Payload: []byte(msg)})
+			}
+
+			close(release)
+			assert.Eventually(t, func() bool {
+				mutex.Lock()
+				defer mutex.Unlock()
+				return len(received) == len(tt.expected)
+			}, time.Second, time.Millisecond)
+			assert.Equal(t, tt.expected, received)
+			assert.Equal(t, tt.dropped, d.Dropped())
+		})
+	}
+
+	t.Run(Block.String(), func(t *testing.T) {
+		release := make(chan struct{})
+		mutex := &sync.Mutex{}
+		received := make([]string, 0)
+
+		d := newDispatcher(blockingCallback(release, mutex, &received),
+			&dispatchOptions{queueSize: 1, policy: Block})
+
+		d.deliver(&Message{Topic: topic, Payload: []byte("1")})
+		assert.Eventually(t, func() bool { return len(d.queue) == 0 }, time.Second, time.Millisecond)
+		d.deliver(&Message{Topic: topic, Payload: []byte("2")})
+
+		delivered := make(chan struct{})
+		go func() {
+			d.deliver(&Message{Topic: topic, Payload: []byte("3")})
+			close(delivered)
+		}()
+
+		select {
+		case <-delivered:
+			t.Fatal("deliver did not block on a full queue")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		// Stopping the dispatcher releases blocked deliveries
+		d.stop()
+		<-delivered
+		close(release)
+		assert.Equal(t, uint64(0), d.Dropped())
+	})
+}
@@ -0,0 +1,716 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tq-systems/public-go-utils/v3/log"
+)
+
+const (
+	// connectTimeout limits establishing a connection, from dialing to receiving CONNACK
+	connectTimeout = 10 * time.Second
+	// sendQueueSize is the number of packets buffered for the writer of a connection
+	sendQueueSize = 64
+)
+
+var (
+	errNotConnected = errors.New("not connected to the MQTT broker")
+	// reconnectAfter waits between connection attempts, replaced by tests
+	reconnectAfter = time.After
+)
+
+/* goBackend implements MQTT 3.1.1 in Go. If brokerPort is 0, brokerAddress is the
+ * path of a Unix socket. Every connection is served by two goroutines: the reader
+ * (serve) handles incoming packets and reports events to the client, while the
+ * writer (write) sends the packets queued in the session and a PINGREQ every
+ * keepalive interval.
+ *
+ * Publications with QoS > 0 are kept until the broker acknowledged them. When the
+ * connection is lost before, they are sent again after reconnecting; this includes
+ * publications made while the client was disconnected.
+ *
+ * Every connection attempt tries the brokers in order, starting with the preferred one.
+ */
+type goBackend struct {
+	client     *client
+	brokers    []goBroker
+	tls        *tls.Config
+	connectMsg []byte
+	keepalive  time.Duration
+	// The delay between failed connection attempts grows from reconnectDelay to maxReconnectDelay
+	reconnectDelay    time.Duration
+	maxReconnectDelay time.Duration
+	// background keeps connecting after the first connection attempt failed
+	background bool
+
+	lock sync.Mutex
+	// Network connection, nil between connection attempts
+	conn net.Conn
+	// Set while the broker accepts packets on conn
+	session *goSession
+	lastID  uint16
+	// Publications awaiting acknowledgement
+	inflight map[uint16]*goInflight
+	// SUBSCRIBE and UNSUBSCRIBE packets awaiting acknowledgement
+	pending map[uint16]bool
+	// Received QoS 2 publications awaiting PUBREL, to drop duplicates
+	received      map[uint16]bool
+	disconnecting bool
+
+	done    chan struct{}
+	running sync.WaitGroup
+}
+
+type goInflight struct {
+	publish *publishPacket
+	// sent is set once the publication was handed to a connection
+	sent bool
+	// released is set when the broker sent PUBREC for a QoS 2 publication
+	released bool
+}
+
+// A goBroker is a broker the backend connects to
+type goBroker struct {
+	network string
+	address string
+	// serverName is verified against the certificate of the broker with TLS
+	serverName string
+}
+
+// newGoBroker returns the broker at brokerAddress. If brokerPort is 0, brokerAddress is
+// the path of a Unix socket.
+func newGoBroker(brokerAddress string, brokerPort int) goBroker {
+	if brokerPort == 0 {
+		return goBroker{network: "unix", address: brokerAddress, serverName: brokerAddress}
+	}
+	return goBroker{
+		network:    "tcp",
+		address:    net.JoinHostPort(brokerAddress, strconv.Itoa(brokerPort)),
+		serverName: brokerAddress,
+	}
+}
+
+// A goSession queues the packets to send on an accepted connection
+type goSession struct {
+	queue chan []byte
+	// closed is closed when the connection ends
+	closed chan struct{}
+}
+
+func (session *goSession) send(data []byte) error {
+	select {
+	case session.queue <- data:
+		return nil
+	case <-session.closed:
+		return errNotConnected
+	}
+}
+
+func newGoBackend(client *client, brokerAddress string, brokerPort int, clientID string,
+	opts *options) (backend, error) {
+	if opts.protocolV5 {
+		return nil, errors.New("the pure-Go MQTT backend does not support MQTT v5")
+	}
+	if opts.psk != nil {
+		return nil, errors.New("the pure-Go MQTT backend does not support TLS-PSK")
+	}
+	keepalive := opts.keepalive.Truncate(time.Second)
+	if keepalive < 0 || keepalive > math.MaxUint16*time.Second {
+		return nil, fmt.Errorf("invalid keepalive %v", opts.keepalive)
+	}
+
+	connect, err := (&connectPacket{
+		clientID:     clientID,
+		cleanSession: opts.cleanSession,
+		keepalive:    uint16(keepalive / time.Second),
+		username:     opts.username,
+		password:     opts.password,
+		will:         opts.will,
+	}).encode()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &goBackend{
+		client:            client,
+		brokers:           []goBroker{newGoBroker(brokerAddress, brokerPort)},
+		connectMsg:        connect,
+		keepalive:         keepalive,
+		reconnectDelay:    opts.reconnectDelay,
+		maxReconnectDelay: opts.maxReconnectDelay,
+		background:        opts.background,
+		inflight:          make(map[uint16]*goInflight),
+		pending:           make(map[uint16]bool),
+		received:          make(map[uint16]bool),
+		done:              make(chan struct{}),
+	}
+	for _, broker := range opts.fallbackBrokers {
+		b.brokers = append(b.brokers, newGoBroker(broker.Address, broker.Port))
+	}
+
+	if opts.tls != nil {
+		tlsConfig, err := goTLSConfig(opts.tls, brokerAddress)
+		if err != nil {
+			return nil, fmt.Errorf("unable to set up MQTT TLS: %w", err)
+		}
+		b.tls = tlsConfig
+	}
+
+	return b, nil
+}
+
+// goTLSConfig converts config to a crypto/tls configuration for connecting to serverName
+func goTLSConfig(config *TLSConfig, serverName string) (*tls.Config, error) {
+	if config.Ciphers != "" {
+		return nil, errors.New("cipher lists are not supported by the pure-Go backend")
+	}
+
+	roots := x509.NewCertPool()
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+	}
+	if config.CAPath != "" {
+		entries, err := os.ReadDir(config.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			// Like OpenSSL, files that do not contain certificates are skipped
+			if pem, err := os.ReadFile(filepath.Join(config.CAPath, entry.Name())); err == nil {
+				roots.AppendCertsFromPEM(pem)
+			}
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    roots,
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch config.Version {
+	case "":
+	case "tlsv1.2":
+		tlsConfig.MaxVersion = tls.VersionTLS12
+	case "tlsv1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported TLS version '%s'", config.Version)
+	}
+
+	if config.Insecure {
+		// Like in libmosquitto, only the hostname is not verified, the certificate chain still is
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("no server certificate")
+			}
+			verifyOpts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range state.PeerCertificates[1:] {
+				verifyOpts.Intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(verifyOpts)
+			return err
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func (b *goBackend) dial(broker goBroker) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	if b.tls != nil {
+		tlsConfig := b.tls.Clone()
+		tlsConfig.ServerName = broker.serverName
+		return tls.DialWithDialer(dialer, broker.network, broker.address, tlsConfig)
+	}
+	return dialer.Dial(broker.network, broker.address)
+}
+
+// dialAny dials the brokers in order and returns the first connection established
+func (b *goBackend) dialAny() (net.Conn, goBroker, error) {
+	errs := make([]error, 0, len(b.brokers))
+	for _, broker := range b.brokers {
+		conn, err := b.dial(broker)
+		if err == nil {
+			return conn, broker, nil
+		}
+		errs = append(errs, fmt.Errorf("unable to connect to MQTT broker %s: %w", broker.address, err))
+	}
+	return nil, goBroker{}, errors.Join(errs...)
+}
+
+func (b *goBackend) connect() error {
+	conn, broker, err := b.dialAny()
+	if err != nil {
+		if !b.background {
+			return err
+		}
+		log.Warningf("%v; retrying in the background", err)
+	}
+
+	b.lock.Lock()
+	b.conn = conn
+	b.lock.Unlock()
+
+	b.running.Add(1)
+	go b.run(conn, broker)
+
+	return nil
+}
+
+// run serves conn and reconnects whenever the connection is lost, until the backend is
+// stopped. conn is nil if the first connection attempt failed.
+func (b *goBackend) run(conn net.Conn, broker goBroker) {
+	defer b.running.Done()
+
+	delay := b.reconnectDelay
+	for {
+		if conn != nil && b.serve(conn, broker) {
+			delay = b.reconnectDelay
+		}
+
+		for conn = nil; conn == nil; conn, broker = b.reconnect() {
+			select {
+			case <-b.done:
+				return
+			case <-reconnectAfter(delay):
+			}
+			delay = min(2*delay, b.maxReconnectDelay)
+		}
+	}
+}
+
+// reconnect dials the brokers again. It returns a nil connection if that failed or the
+// backend is disconnecting.
+func (b *goBackend) reconnect() (net.Conn, goBroker) {
+	conn, broker, err := b.dialAny()
+	if err != nil {
+		log.Debugf("Unable to reconnect to MQTT broker: %v", err)
+		return nil, broker
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.disconnecting {
+		conn.Close()
+		return nil, broker
+	}
+	b.conn = conn
+
+	return conn, broker
+}
+
+// handshake sends CONNECT and returns the return code and the session present flag of
+// the CONNACK received in response
+func (b *goBackend) handshake(conn net.Conn, reader *bufio.Reader) (byte, bool, error) {
+	if err := conn.SetDeadline(time.Now().Add(connectTimeout)); err != nil {
+		return 0, false, err
+	}
+	if _, err := conn.Write(b.connectMsg); err != nil {
+		return 0, false, err
+	}
+	p, err := readPacket(reader)
+	if err != nil {
+		return 0, false, err
+	}
+	code, sessionPresent, err := decodeConnack(p)
+	if err != nil {
+		return 0, false, err
+	}
+	return code, sessionPresent, conn.SetDeadline(time.Time{})
+}
+
+// serve runs an MQTT session on conn until the connection is closed. It returns whether
+// the broker accepted the connection.
+func (b *goBackend) serve(conn net.Conn, broker goBroker) bool {
+	defer func() {
+		b.lock.Lock()
+		b.conn = nil
+		b.lock.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	code, sessionPresent, err := b.handshake(conn, reader)
+	if err != nil {
+		log.Warningf("MQTT connection to %s failed: %v", broker.address, err)
+		return false
+	}
+	if refused := connackError(code); refused != nil {
+		b.client.onConnect(refused)
+		return false
+	}
+
+	session := &goSession{
+		queue:  make(chan []byte, sendQueueSize),
+		closed: make(chan struct{}),
+	}
+
+	b.lock.Lock()
+	b.session = session
+	if !sessionPresent {
+		// A new session reuses the packet identifiers of QoS 2 messages awaiting PUBREL
+		clear(b.received)
+	}
+	resend := b.resendPackets()
+	b.lock.Unlock()
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		b.write(conn, session)
+	}()
+
+	for _, data := range resend {
+		_ = session.send(data)
+	}
+
+	b.client.onConnect(nil)
+
+	for err == nil {
+		if b.keepalive > 0 {
+			// The broker answers the PINGREQ sent every keepalive interval
+			err = conn.SetReadDeadline(time.Now().Add(b.keepalive * 3 / 2))
+		}
+		var p *packet
+		if err == nil {
+			p, err = readPacket(reader)
+		}
+		if err == nil {
+			err = b.handle(session, p)
+		}
+	}
+	log.Debugf("MQTT connection to %s closed: %v", broker.address, err)
+
+	b.lock.Lock()
+	b.session = nil
+	clear(b.pending)
+	disconnecting := b.disconnecting
+	b.lock.Unlock()
+
+	close(session.closed)
+	conn.Close()
+	<-writerDone
+
+	switch {
+	case disconnecting:
+		b.client.onDisconnect(DisconnectRequested, nil)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		b.client.onDisconnect(DisconnectKeepaliveTimeout, err)
+	case errors.Is(err, errMalformedPacket):
+		b.client.onDisconnect(DisconnectProtocolError, err)
+	default:
+		b.client.onDisconnect(DisconnectConnectionLost, err)
+	}
+	return true
+}
+
+// write sends the packets queued in session to conn, and a PINGREQ every keepalive interval
+func (b *goBackend) write(conn net.Conn, session *goSession) {
+	var ping <-chan time.Time
+	if b.keepalive > 0 {
+		ticker := time.NewTicker(b.keepalive)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		var data []byte
+		select {
+		case data = <-session.queue:
+		case <-ping:
+			data = encodeEmpty(packetPingreq)
+		case <-session.closed:
+			return
+		}
+
+		// On errors, closing the connection makes the reader end the session
+		if _, err := conn.Write(data); err != nil || data[0]>>4 == packetDisconnect {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// resendPackets returns the packets to send for the unacknowledged publications after
+// reconnecting. Must be called with b.lock held.
+func (b *goBackend) resendPackets() [][]byte {
+	packets := make([][]byte, 0, len(b.inflight))
+	for _, id := range slices.Sorted(maps.Keys(b.inflight)) {
+		inflight := b.inflight[id]
+		if inflight.released {
+			packets = append(packets, encodeAck(packetPubrel, id))
+			continue
+		}
+		publish := *inflight.publish
+		publish.dup = inflight.sent
+		inflight.sent = true
+		// The packet was encoded successfully when it was published
+		data, _ := publish.encode()
+		packets = append(packets, data)
+	}
+	return packets
+}
+
+// handle processes a packet received from the broker
+func (b *goBackend) handle(session *goSession, p *packet) error {
+	if p.kind == packetPublish {
+		publish, err := decodePublish(p)
+		if err != nil {
+			return err
+		}
+		return b.handlePublish(session, publish)
+	}
+
+	if p.kind == packetPingresp {
+		return nil
+	}
+
+	id, err := decodeID(p)
+	if err != nil {
+		return err
+	}
+
+	switch p.kind {
+	case packetPuback, packetPubcomp:
+		b.lock.Lock()
+		_, ok := b.inflight[id]
+		delete(b.inflight, id)
+		b.lock.Unlock()
+		if ok {
+			b.client.onPubSub(int(id))
+		}
+	case packetPubrec:
+		b.lock.Lock()
+		if inflight, ok := b.inflight[id]; ok {
+			inflight.released = true
+		}
+		b.lock.Unlock()
+		return session.send(encodeAck(packetPubrel, id))
+	case packetPubrel:
+		b.lock.Lock()
+		delete(b.received, id)
+		b.lock.Unlock()
+		return session.send(encodeAck(packetPubcomp, id))
+	case packetSuback, packetUnsuback:
+		if p.kind == packetSuback && bytes.IndexByte(p.body[2:], subackFailure) >= 0 {
+			log.Warningf("MQTT broker rejected subscription %d", id)
+		}
+		b.lock.Lock()
+		ok := b.pending[id]
+		delete(b.pending, id)
+		b.lock.Unlock()
+		if ok {
+			b.client.onPubSub(int(id))
+		}
+	default:
+		return fmt.Errorf("%w: unexpected MQTT packet type %d", errMalformedPacket, p.kind)
+	}
+
+	return nil
+}
+
+// handlePublish delivers a message received from the broker and acknowledges it
+func (b *goBackend) handlePublish(session *goSession, publish *publishPacket) error {
+	message := &Message{
+		Topic:     publish.topic,
+		Payload:   publish.payload,
+		QoS:       publish.qos,
+		Retain:    publish.retain,
+		Duplicate: publish.dup,
+		MessageID: int(publish.id),
+	}
+
+	switch publish.qos {
+	case 1:
+		b.client.onMessage(message)
+		return session.send(encodeAck(packetPuback, publish.id))
+	case 2:
+		b.lock.Lock()
+		duplicate := b.received[publish.id]
+		b.received[publish.id] = true
+		b.lock.Unlock()
+		if !duplicate {
+			b.client.onMessage(message)
+		}
+		return session.send(encodeAck(packetPubrec, publish.id))
+	default:
+		b.client.onMessage(message)
+		return nil
+	}
+}
+
+// nextID returns an unused packet identifier. Must be called with b.lock held.
+func (b *goBackend) nextID() (uint16, error) {
+	for range math.MaxUint16 {
+		b.lastID++
+		if b.lastID == 0 {
+			b.lastID = 1
+		}
+		if b.inflight[b.lastID] == nil && !b.pending[b.lastID] {
+			return b.lastID, nil
+		}
+	}
+	return 0, errors.New("no free MQTT packet identifier")
+}
+
+// sendPending sends a packet that is acknowledged with the packet identifier returned by
+// encode. It fails if the backend is not connected.
+func (b *goBackend) sendPending(encode func(id uint16) ([]byte, error)) (int, error) {
+	b.lock.Lock()
+	session := b.session
+	if session == nil {
+		b.lock.Unlock()
+		return 0, errNotConnected
+	}
+	id, err := b.nextID()
+	if err != nil {
+		b.lock.Unlock()
+		return 0, err
+	}
+	b.pending[id] = true
+	b.lock.Unlock()
+
+	data, err := encode(id)
+	if err == nil {
+		err = session.send(data)
+	}
+	if err != nil {
+		b.lock.Lock()
+		delete(b.pending, id)
+		b.lock.Unlock()
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// subscribe ignores id, which only exists in MQTT v5
+func (b *goBackend) subscribe(topics []string, opts SubscribeOptions, _ int) (int, error) {
+	for _, topic := range topics {
+		if !ValidTopicFilter(topic) {
+			return 0, fmt.Errorf("invalid topic filter '%s'", topic)
+		}
+	}
+	return b.sendPending(func(id uint16) ([]byte, error) {
+		return encodeSubscribe(id, topics, opts.QoS)
+	})
+}
+
+func (b *goBackend) unsubscribe(topics []string) (int, error) {
+	return b.sendPending(func(id uint16) ([]byte, error) {
+		return encodeUnsubscribe(id, topics)
+	})
+}
+
+func (b *goBackend) publish(topic string, qos byte, retain bool, payload []byte, _ *Properties) (int, error) {
+	publish := &publishPacket{topic: topic, payload: payload, qos: qos, retain: retain}
+
+	b.lock.Lock()
+	session := b.session
+	if qos > 0 {
+		id, err := b.nextID()
+		if err != nil {
+			b.lock.Unlock()
+			return 0, err
+		}
+		// The payload is kept for sending it again after reconnecting
+		publish.id, publish.payload = id, bytes.Clone(payload)
+	}
+	data, err := publish.encode()
+	if err != nil {
+		b.lock.Unlock()
+		return 0, err
+	}
+	if qos > 0 {
+		b.inflight[publish.id] = &goInflight{publish: publish, sent: session != nil}
+	}
+	b.lock.Unlock()
+
+	if session == nil {
+		if qos == 0 {
+			return 0, errNotConnected
+		}
+		return int(publish.id), nil
+	}
+
+	// Publications with QoS > 0 are sent again after reconnecting if this fails
+	if err := session.send(data); err != nil && qos == 0 {
+		return 0, err
+	}
+
+	return int(publish.id), nil
+}
+
+func (b *goBackend) disconnect() {
+	b.lock.Lock()
+	b.disconnecting = true
+	session, conn := b.session, b.conn
+	b.lock.Unlock()
+
+	switch {
+	case session != nil:
+		// The writer closes the connection after sending DISCONNECT
+		_ = session.send(encodeEmpty(packetDisconnect))
+	case conn != nil:
+		conn.Close()
+	}
+}
+
+func (b *goBackend) stop() {
+	b.lock.Lock()
+	b.disconnecting = true
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	b.lock.Unlock()
+
+	close(b.done)
+	b.running.Wait()
+}
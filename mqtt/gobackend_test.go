@@ -0,0 +1,862 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptBroker is a minimal MQTT 3.1.1 broker for testing the pure-Go backend. It
+// serves every connection on its own; publications are only sent back to the
+// connection that published them.
+type scriptBroker struct {
+	listener net.Listener
+	// connack is the CONNACK return code sent to clients
+	connack byte
+
+	lock sync.Mutex
+	// ackPublish and ackSubscribe control whether publications and subscriptions are acknowledged
+	ackPublish    bool
+	ackSubscribe  bool
+	conns         map[net.Conn]bool
+	subscriptions []string
+	// subscribedQoS holds the requested QoS of each entry of subscriptions
+	subscribedQoS []byte
+	// requests counts the SUBSCRIBE and UNSUBSCRIBE packets received
+	requests     int
+	unsubscribed []string
+	published    []string
+	// active holds the topic filters subscribed to by the last connection
+	active map[string]bool
+	// retained holds the payloads of retained messages by topic
+	retained map[string][]byte
+	// heldSubacks holds the SUBACKs not sent while ackSubscribe is unset, see releaseSubacks
+	heldSubacks map[net.Conn][]byte
+	// forwardID is the packet identifier of messages forwarded with QoS 2, see setForwardQoS2
+	forwardID uint16
+}
+
+func startScriptBroker(t *testing.T, network string, address string, connack byte) *scriptBroker {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := &scriptBroker{
+		listener:     listener,
+		connack:      connack,
+		ackPublish:   true,
+		ackSubscribe: true,
+		conns:        make(map[net.Conn]bool),
+		retained:     make(map[string][]byte),
+		heldSubacks:  make(map[net.Conn][]byte),
+	}
+	t.Cleanup(broker.stop)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			broker.lock.Lock()
+			broker.conns[conn] = true
+			broker.lock.Unlock()
+			go broker.serve(conn)
+		}
+	}()
+
+	return broker
+}
+
+func (broker *scriptBroker) port() int {
+	return broker.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (broker *scriptBroker) subscribed() []string {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	return append([]string{}, broker.subscriptions...)
+}
+
+func (broker *scriptBroker) subscribedQoSLevels() []byte {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	return append([]byte{}, broker.subscribedQoS...)
+}
+
+func (broker *scriptBroker) unsubscribedTopics() []string {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	return append([]string{}, broker.unsubscribed...)
+}
+
+func (broker *scriptBroker) activeFilters() []string {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	filters := make([]string, 0, len(broker.active))
+	for filter := range broker.active {
+		filters = append(filters, filter)
+	}
+	slices.Sort(filters)
+	return filters
+}
+
+func (broker *scriptBroker) requestCount() int {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	return broker.requests
+}
+
+func (broker *scriptBroker) publishedPayloads() []string {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	return append([]string{}, broker.published...)
+}
+
+func (broker *scriptBroker) setAckSubscribe(ackSubscribe bool) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	broker.ackSubscribe = ackSubscribe
+}
+
+// releaseSubacks sends the SUBACKs held back while subscriptions were not acknowledged
+func (broker *scriptBroker) releaseSubacks() {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	for conn, data := range broker.heldSubacks {
+		_, _ = conn.Write(data)
+	}
+	clear(broker.heldSubacks)
+}
+
+// setForwardQoS2 forwards QoS 2 messages with QoS 2 and the packet identifier id, but
+// never sends PUBREL for them
+func (broker *scriptBroker) setForwardQoS2(id uint16) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	broker.forwardID = id
+}
+
+func (broker *scriptBroker) setAckPublish(ackPublish bool) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	broker.ackPublish = ackPublish
+}
+
+// stop closes the listener and all connections
+func (broker *scriptBroker) stop() {
+	broker.listener.Close()
+	broker.dropConnections()
+}
+
+// dropConnections closes all connections without a DISCONNECT
+func (broker *scriptBroker) dropConnections() {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	for conn := range broker.conns {
+		conn.Close()
+		delete(broker.conns, conn)
+	}
+}
+
+func (broker *scriptBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	if p, err := readPacket(reader); err != nil || p.kind != packetConnect {
+		return
+	}
+	if _, err := conn.Write([]byte{0x20, 0x02, 0x00, broker.connack}); err != nil || broker.connack != 0 {
+		return
+	}
+
+	filters := make([]string, 0)
+	// filterQoS holds the requested QoS of filters
+	filterQoS := make(map[string]byte)
+	broker.lock.Lock()
+	broker.active = make(map[string]bool)
+	broker.lock.Unlock()
+	for {
+		p, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		var response []byte
+
+		switch p.kind {
+		case packetSubscribe:
+			suback := p.body[:2:2]
+			broker.lock.Lock()
+			broker.requests++
+			for rest := p.body[2:]; len(rest) > 0; rest = rest[1:] {
+				var filter string
+				filter, rest, _ = readString(rest)
+				filters = append(filters, filter)
+				filterQoS[filter] = rest[0]
+				broker.subscriptions = append(broker.subscriptions, filter)
+				broker.active[filter] = true
+				broker.subscribedQoS = append(broker.subscribedQoS, rest[0])
+				suback = append(suback, rest[0])
+			}
+			var retained []byte
+			for topic, payload := range broker.retained {
+				for rest := p.body[2:]; len(rest) > 0; rest = rest[1:] {
+					var filter string
+					filter, rest, _ = readString(rest)
+					if TopicMatches(filter, topic) {
+						data, _ := (&publishPacket{topic: topic, payload: payload, retain: true}).encode()
+						retained = append(retained, data...)
+						break
+					}
+				}
+			}
+			response = append((&packet{kind: packetSuback, body: suback}).encode(), retained...)
+			if !broker.ackSubscribe {
+				broker.heldSubacks[conn] = append(broker.heldSubacks[conn], response...)
+				response = nil
+			}
+			broker.lock.Unlock()
+		case packetUnsubscribe:
+			broker.lock.Lock()
+			broker.requests++
+			for rest := p.body[2:]; len(rest) > 0; {
+				var filter string
+				filter, rest, _ = readString(rest)
+				filters = slices.DeleteFunc(filters, func(f string) bool { return f == filter })
+				broker.unsubscribed = append(broker.unsubscribed, filter)
+				delete(broker.active, filter)
+			}
+			broker.lock.Unlock()
+			response = encodeAck(packetUnsuback, uint16(p.body[0])<<8|uint16(p.body[1]))
+		case packetPublish:
+			publish, _ := decodePublish(p)
+			broker.lock.Lock()
+			ackPublish := broker.ackPublish
+			forwardID := broker.forwardID
+			broker.published = append(broker.published, string(publish.payload))
+			if publish.retain && len(publish.payload) == 0 {
+				delete(broker.retained, publish.topic)
+			} else if publish.retain {
+				broker.retained[publish.topic] = publish.payload
+			}
+			broker.lock.Unlock()
+			if publish.qos == 1 && ackPublish {
+				response = encodeAck(packetPuback, publish.id)
+			} else if publish.qos == 2 && ackPublish {
+				response = encodeAck(packetPubrec, publish.id)
+			}
+			for _, filter := range filters {
+				if TopicMatches(filter, publish.topic) {
+					// Messages are forwarded with QoS 1 at most, as QoS 2 is not implemented
+					forward := &publishPacket{topic: publish.topic, payload: publish.payload}
+					forward.qos = min(publish.qos, filterQoS[filter], 1)
+					if forward.qos > 0 {
+						forward.id = publish.id
+					}
+					if forwardID != 0 && min(publish.qos, filterQoS[filter]) == 2 {
+						forward.qos, forward.id = 2, forwardID
+					}
+					data, _ := forward.encode()
+					response = append(response, data...)
+					break
+				}
+			}
+		case packetPubrel:
+			id, _ := decodeID(p)
+			response = encodeAck(packetPubcomp, id)
+		case packetPingreq:
+			response = encodeEmpty(packetPingresp)
+		case packetDisconnect:
+			return
+		}
+
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+	}
+}
+
+// receiveMessages subscribes to topic and returns a channel receiving the payloads
+func receiveMessages(t *testing.T, client Client) chan string {
+	received := make(chan string, 10)
+	_, err := client.Subscribe(topic, func(_ string, message []byte) {
+		received <- string(message)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
+
+func expectMessage(t *testing.T, received chan string, expected string) {
+	select {
+	case message := <-received:
+		assert.Equal(t, expected, message)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message %s not received", expected)
+	}
+}
+
+// unusedPort returns a TCP port nobody is listening on
+func unusedPort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestGoBackend(t *testing.T) {
+	t.Run("Publish and subscribe", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		received := receiveMessages(t, client)
+		assert.Equal(t, []string{topic}, broker.subscribed())
+
+		for qos := byte(0); qos <= 2; qos++ {
+			assert.Nil(t, client.PublishRaw(topic, qos, false, []byte(strconv.Itoa(int(qos)))))
+			expectMessage(t, received, strconv.Itoa(int(qos)))
+		}
+
+		// Topics are not truncated to the 65535 bytes allowed by MQTT
+		assert.ErrorIs(t, client.PublishRaw(strings.Repeat("a", 65536), 1, false, nil), errStringTooLong)
+	})
+
+	t.Run("Message metadata", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		assert.Nil(t, client.PublishRaw("meta/retained", 1, true, []byte("retained")))
+
+		messages := make(chan *Message, 10)
+		receive := func(message *Message) {
+			messages <- message
+		}
+		_, err = client.SubscribeMessage(context.Background(), "meta/+", receive)
+		assert.Nil(t, err)
+		_, err = client.SubscribeMessage(context.Background(), "low/+", receive, WithQoS(0))
+		assert.Nil(t, err)
+
+		expect := func() *Message {
+			select {
+			case message := <-messages:
+				return message
+			case <-time.After(5 * time.Second):
+				t.Fatal("message not received")
+				return nil
+			}
+		}
+
+		message := expect()
+		assert.Equal(t, "meta/retained", message.Topic)
+		assert.True(t, message.Retain)
+		assert.Equal(t, byte(0), message.QoS)
+
+		assert.Nil(t, client.PublishRaw("meta/live", 1, false, []byte("live")))
+		message = expect()
+		assert.False(t, message.Retain)
+		assert.False(t, message.Duplicate)
+		assert.Equal(t, byte(1), message.QoS)
+		assert.NotZero(t, message.MessageID)
+
+		// The QoS is limited by the subscription
+		assert.Nil(t, client.PublishRaw("low/live", 1, false, []byte("low")))
+		message = expect()
+		assert.Equal(t, "low/live", message.Topic)
+		assert.Equal(t, byte(0), message.QoS)
+		assert.Zero(t, message.MessageID)
+	})
+
+	t.Run("Reconnect and resubscribe", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo(),
+			WithBirthMessage("birth", []byte("online"), 0, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		received := receiveMessages(t, client)
+		broker.dropConnections()
+
+		// Publications with QoS > 0 made while disconnected are sent after reconnecting
+		assert.Nil(t, client.PublishRaw(topic, 1, false, []byte("queued")))
+		assert.Contains(t, broker.publishedPayloads(), "queued")
+
+		// The subscription and the birth message are restored after the queued publication
+		assert.Eventually(t, func() bool {
+			return len(broker.subscribed()) == 2 &&
+				strings.Count(strings.Join(broker.publishedPayloads(), ","), "online") == 2
+		}, time.Second, 10*time.Millisecond)
+		assert.Nil(t, client.PublishRaw(topic, 1, false, []byte("resubscribed")))
+		expectMessage(t, received, "resubscribed")
+	})
+
+	t.Run("Reconnect between PUBREC and PUBREL", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		broker.setForwardQoS2(7)
+		client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		received := receiveMessages(t, client)
+		assert.Nil(t, client.PublishRaw(topic, 2, false, []byte("first")))
+		expectMessage(t, received, "first")
+
+		// The new clean session reuses the packet identifier awaiting PUBREL
+		broker.dropConnections()
+		assert.Eventually(t, func() bool {
+			return len(broker.subscribed()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Nil(t, client.PublishRaw(topic, 2, false, []byte("second")))
+		expectMessage(t, received, "second")
+	})
+
+	t.Run("Subscription QoS", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		callback := func(string, []byte) {}
+		_, err = client.Subscribe("a", callback, WithQoS(0))
+		assert.Nil(t, err)
+		// Lower or equal QoS levels are covered by the existing broker subscription
+		_, err = client.Subscribe("a", callback, WithQoS(0))
+		assert.Nil(t, err)
+		sub, err := client.Subscribe("a", callback, WithQoS(1))
+		assert.Nil(t, err)
+		_, err = client.Subscribe("b", callback)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "a", "b"}, broker.subscribed())
+		assert.Equal(t, []byte{0, 1, 2}, broker.subscribedQoSLevels())
+
+		// The highest QoS requested is kept and restored after reconnecting
+		sub.Unsubscribe()
+		broker.dropConnections()
+		assert.Eventually(t, func() bool {
+			return len(broker.subscribed()) == 5
+		}, 5*time.Second, 10*time.Millisecond)
+		levels := map[string]byte{}
+		for i, filter := range broker.subscribed()[3:] {
+			levels[filter] = broker.subscribedQoSLevels()[3+i]
+		}
+		assert.Equal(t, map[string]byte{"a": 1, "b": 2}, levels)
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		callback := func(string, []byte) {}
+		first, err := client.Subscribe("a", callback)
+		assert.Nil(t, err)
+		second, err := client.Subscribe("a", callback)
+		assert.Nil(t, err)
+
+		// The broker subscription is only removed with the last subscription of a topic
+		assert.Nil(t, first.UnsubscribeContext(context.Background()))
+		assert.Empty(t, broker.unsubscribedTopics())
+		assert.Nil(t, second.UnsubscribeContext(context.Background()))
+		assert.Equal(t, []string{"a"}, broker.unsubscribedTopics())
+
+		assert.ErrorIs(t, second.UnsubscribeContext(context.Background()), ErrNotSubscribed)
+		second.Unsubscribe()
+		assert.Equal(t, 2, broker.requestCount())
+
+		// Without a connection, the subscription is removed without waiting
+		third, err := client.Subscribe("b", callback)
+		assert.Nil(t, err)
+		broker.stop()
+		assert.Eventually(t, func() bool {
+			return !client.IsConnected()
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Nil(t, third.UnsubscribeContext(context.Background()))
+		assert.ErrorIs(t, third.UnsubscribeContext(context.Background()), ErrNotSubscribed)
+	})
+
+	t.Run("Unsubscribe while disconnected", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		port := broker.port()
+		client, err := NewClientWithOptions("127.0.0.1", port, "client", WithPureGo(), WithCleanSession(false),
+			WithReconnectDelay(10*time.Millisecond, 10*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		callback := func(string, []byte) {}
+		subs := make([]Subscription, 0)
+		for _, topic := range []string{"a", "b", "c"} {
+			sub, err := client.Subscribe(topic, callback)
+			assert.Nil(t, err)
+			subs = append(subs, sub)
+		}
+
+		broker.stop()
+		assert.Eventually(t, func() bool {
+			return !client.IsConnected()
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Nil(t, subs[0].UnsubscribeContext(context.Background()))
+		assert.Nil(t, subs[1].UnsubscribeContext(context.Background()))
+		// Subscribed again before reconnecting, so it is kept
+		_, err = client.Subscribe("b", callback)
+		assert.Nil(t, err)
+
+		// The broker kept the session, so the subscription of "a" is removed once connected
+		broker = startScriptBroker(t, "tcp", fmt.Sprintf("127.0.0.1:%d", port), 0)
+		assert.Eventually(t, func() bool {
+			return len(broker.unsubscribedTopics()) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{"a"}, broker.unsubscribedTopics())
+		assert.ElementsMatch(t, []string{"b", "c"}, broker.subscribed())
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sub, err := client.Subscribe("a", func(string, []byte) {})
+		assert.Nil(t, err)
+
+		// Actions waiting for a confirmation fail when the client is closed
+		broker.setAckPublish(false)
+		published := make(chan error)
+		go func() {
+			published <- client.PublishRaw(topic, 1, false, []byte("unconfirmed"))
+		}()
+		assert.Eventually(t, func() bool {
+			return slices.Contains(broker.publishedPayloads(), "unconfirmed")
+		}, 5*time.Second, 10*time.Millisecond)
+		client.Close()
+		assert.ErrorIs(t, <-published, ErrClientClosed)
+
+		assert.ErrorIs(t, sub.UnsubscribeContext(context.Background()), ErrClientClosed)
+		sub.Unsubscribe()
+		assert.ErrorIs(t, client.UnsubscribeMany(context.Background(), []Subscription{sub}), ErrClientClosed)
+		_, err = client.Subscribe("b", func(string, []byte) {})
+		assert.ErrorIs(t, err, ErrClientClosed)
+	})
+
+	t.Run("Unix socket", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "broker.sock")
+		startScriptBroker(t, "unix", socket, 0)
+		client, err := NewClientWithOptions(socket, 0, "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		received := receiveMessages(t, client)
+		assert.Nil(t, client.PublishRaw(topic, 1, false, []byte("unix")))
+		expectMessage(t, received, "unix")
+	})
+
+	t.Run("Confirm timeout", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		broker.setAckPublish(false)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, client.PublishRawContext(ctx, topic, 1, false, []byte("lost")), context.DeadlineExceeded)
+	})
+
+	t.Run("Cancel while waiting for confirmation", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		c, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		client := c.(*client)
+		broker.setAckSubscribe(false)
+		broker.setAckPublish(false)
+
+		// cancelWaiting cancels the returned context once a call waits for a confirmation
+		cancelWaiting := func() context.Context {
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				assert.Eventually(t, func() bool {
+					waiting := false
+					locked(client.currentMsgLock, func() {
+						waiting = len(client.confirmWaiters) > 0
+					})
+					return waiting
+				}, 5*time.Second, time.Millisecond)
+				cancel()
+			}()
+			return ctx
+		}
+		confirmWaiters := func() int {
+			n := 0
+			locked(client.currentMsgLock, func() {
+				n = len(client.confirmWaiters)
+			})
+			return n
+		}
+
+		_, err = client.SubscribeContext(cancelWaiting(), topic, func(string, []byte) {})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Zero(t, confirmWaiters())
+		assert.Empty(t, subscribedTopics(client))
+
+		err = client.PublishRawContext(cancelWaiting(), topic, 1, false, []byte("unconfirmed"))
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Zero(t, confirmWaiters())
+	})
+
+	t.Run("Reused message ID", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		c, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		client := c.(*client)
+
+		// The backend reuses the ID of a request lost with the connection while its
+		// caller is still waiting
+		var lost chan error
+		locked(client.currentMsgLock, func() {
+			lost = client.initConfirmWaiter(1000)
+			client.initConfirmWaiter(1000)
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.ErrorIs(t, client.waitForConfirm(ctx, 1000, lost), errConfirmLost)
+	})
+
+	t.Run("Refused connection", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 5)
+		_, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		assert.ErrorContains(t, err, "not authorised")
+	})
+
+	t.Run("Fallback brokers", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		client, err := NewClientWithOptions("127.0.0.1", unusedPort(t), "client", WithPureGo(),
+			WithFallbackBrokers(BrokerAddress{"127.0.0.1", unusedPort(t)}, BrokerAddress{"127.0.0.1", broker.port()}),
+			WithReconnectDelay(10*time.Millisecond, 10*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		received := receiveMessages(t, client)
+		broker.dropConnections()
+		assert.Eventually(t, func() bool {
+			return len(broker.subscribed()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Nil(t, client.PublishRaw(topic, 1, false, []byte("reconnected")))
+		expectMessage(t, received, "reconnected")
+
+		_, err = NewClientWithOptions("127.0.0.1", unusedPort(t), "client", WithPureGo(),
+			WithFallbackBrokers(BrokerAddress{"127.0.0.1", unusedPort(t)}))
+		assert.ErrorContains(t, err, "unable to connect")
+	})
+
+	t.Run("Background connect", func(t *testing.T) {
+		port := unusedPort(t)
+		client, err := NewClientWithOptions("127.0.0.1", port, "client", WithPureGo(), WithBackgroundConnect(),
+			WithReconnectDelay(10*time.Millisecond, 10*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		assert.False(t, client.IsConnected())
+
+		// The subscription is made once connected
+		received := receiveMessages(t, client)
+
+		broker := startScriptBroker(t, "tcp", fmt.Sprintf("127.0.0.1:%d", port), 0)
+		assert.Eventually(t, func() bool {
+			return len(broker.subscribed()) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.True(t, client.IsConnected())
+		assert.Nil(t, client.PublishRaw(topic, 1, false, []byte("connected")))
+		expectMessage(t, received, "connected")
+	})
+
+	t.Run("Reconnect backoff", func(t *testing.T) {
+		// The listener closes all connections before the MQTT handshake
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+
+		// The delays are recorded instead of waited for
+		delays := make(chan time.Duration, 100)
+		defer func(after func(time.Duration) <-chan time.Time) {
+			reconnectAfter = after
+		}(reconnectAfter)
+		reconnectAfter = func(delay time.Duration) <-chan time.Time {
+			select {
+			case delays <- delay:
+			default:
+			}
+			return time.After(time.Millisecond)
+		}
+
+		client, err := NewClientWithOptions("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, "client",
+			WithPureGo(), WithBackgroundConnect(), WithReconnectDelay(10*time.Millisecond, 80*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		// The delay doubles up to the maximum, as the handshake of every attempt fails
+		expected := []time.Duration{10, 20, 40, 80, 80, 80}
+		for i, milliseconds := range expected {
+			select {
+			case delay := <-delays:
+				assert.Equal(t, milliseconds*time.Millisecond, delay)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("reconnect attempt %d not made", i+1)
+			}
+		}
+	})
+
+	t.Run("Shared subscription", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo(),
+			WithReconnectDelay(10*time.Millisecond, 10*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		filter := SharedFilter("group", "shared/+")
+		received := make(chan string, 10)
+		sub, err := client.Subscribe(filter, func(topic string, _ []byte) {
+			received <- topic
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"$share/group/shared/+"}, broker.subscribed())
+
+		assert.Nil(t, client.PublishRaw("shared/a", 1, false, []byte("a")))
+		assert.Equal(t, "shared/a", <-received)
+
+		// The shared subscription is restored after a reconnect
+		broker.dropConnections()
+		assert.Eventually(t, func() bool {
+			return len(broker.subscribed()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, filter, broker.subscribed()[1])
+		assert.Nil(t, client.PublishRaw("shared/b", 1, false, []byte("b")))
+		assert.Equal(t, "shared/b", <-received)
+
+		assert.Nil(t, sub.UnsubscribeContext(context.Background()))
+		assert.Equal(t, []string{filter}, broker.unsubscribedTopics())
+
+		_, err = client.GetRetainedPattern(context.Background(), filter)
+		assert.ErrorContains(t, err, "shared subscription")
+	})
+
+	t.Run("Subscription identifiers", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		c, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		received := make(chan string, 10)
+		for _, filter := range []string{SharedFilter("group", "ids/+"), "ids/#"} {
+			_, err := c.Subscribe(filter, func(string, []byte) {
+				received <- filter
+			})
+			assert.Nil(t, err)
+		}
+		receivedFilters := func() []string {
+			filters := make([]string, 0)
+			for len(received) > 0 {
+				filters = append(filters, <-received)
+			}
+			return filters
+		}
+
+		// Without MQTT v5, messages run all matching callbacks
+		client := c.(*client)
+		client.onMessage(&Message{Topic: "ids/a"})
+		assert.ElementsMatch(t, []string{"$share/group/ids/+", "ids/#"}, receivedFilters())
+
+		// The identifiers the broker sent select the subscriptions a message was delivered for
+		locked(client.lock, func() {
+			client.subscribedTopics["$share/group/ids/+"].id = 2
+			client.subscribedTopics["ids/#"].id = nonSharedSubscriptionID
+		})
+		client.onMessage(&Message{Topic: "ids/a", subscriptionIDs: []int{nonSharedSubscriptionID}})
+		assert.Equal(t, []string{"ids/#"}, receivedFilters())
+		client.onMessage(&Message{Topic: "ids/a", subscriptionIDs: []int{2}})
+		assert.Equal(t, []string{"$share/group/ids/+"}, receivedFilters())
+	})
+
+	t.Run("Unsupported options", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		_, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo(), WithProtocolV5())
+		assert.NotNil(t, err)
+		_, err = NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo(),
+			WithTLSPSK(PSKConfig{PSK: "1234", Identity: "client"}))
+		assert.NotNil(t, err)
+	})
+}
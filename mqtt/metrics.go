@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* A MetricsCollector receives measurements of the activity of a Client, see WithMetrics.
+ * Its methods are called by the goroutines using the client as well as by the network
+ * thread of the backend, so they must be safe for concurrent use and return quickly.
+ */
+type MetricsCollector interface {
+	// MessageReceived is called for every message received from the broker
+	MessageReceived(topic string, size int)
+	// MessagePublished is called for every message handed to the broker
+	MessagePublished(topic string, size int)
+	// PublishConfirmed is called when the broker confirmed a publication with QoS > 0
+	PublishConfirmed(latency time.Duration)
+	// ConfirmTimedOut is called when an action failed with ErrConfirmTimedOut
+	ConfirmTimedOut()
+	// Reconnected is called when the connection is established again after it was lost
+	Reconnected()
+	// CallbackDone is called after the callback of a subscription handled a message
+	CallbackDone(topic string, duration time.Duration)
+}
+
+// WithMetrics reports the activity of the client to collector, e.g. a Metrics
+func WithMetrics(collector MetricsCollector) Option {
+	return func(opts *options) {
+		opts.metrics = collector
+	}
+}
+
+// measureCallback returns callback, reporting its execution time if metrics are collected
+func (client *client) measureCallback(callback MessageCallback) MessageCallback {
+	if client.metrics == nil {
+		return callback
+	}
+	return func(message *Message) {
+		start := time.Now()
+		callback(message)
+		client.metrics.CallbackDone(message.Topic, time.Since(start))
+	}
+}
+
+// durationBuckets are the upper bounds of the histogram buckets, in seconds
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// A histogram counts durations in durationBuckets
+type histogram struct {
+	// buckets holds the number of durations per bucket, the last one for larger durations
+	buckets [9]uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(duration time.Duration) {
+	seconds := duration.Seconds()
+	i, _ := slices.BinarySearch(durationBuckets, seconds)
+	h.buckets[i]++
+	h.count++
+	h.sum += seconds
+}
+
+// A traffic counts the messages and bytes of a topic
+type traffic struct {
+	messages uint64
+	bytes    uint64
+}
+
+/* Metrics is an in-memory MetricsCollector. It can be shared by several clients and
+ * exported in the Prometheus text format with WritePrometheus, e.g. using
+ * rest.NewPrometheusResponse. Messages are counted per topic, so it should only be
+ * used with a limited set of topics.
+ */
+type Metrics struct {
+	lock             sync.Mutex
+	received         map[string]*traffic
+	published        map[string]*traffic
+	confirmLatency   histogram
+	callbackDuration histogram
+	confirmTimeouts  uint64
+	reconnects       uint64
+}
+
+// NewMetrics creates empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		received:  make(map[string]*traffic),
+		published: make(map[string]*traffic),
+	}
+}
+
+func countTraffic(traffics map[string]*traffic, topic string, size int) {
+	t, ok := traffics[topic]
+	if !ok {
+		t = &traffic{}
+		traffics[topic] = t
+	}
+	t.messages++
+	t.bytes += uint64(size)
+}
+
+// MessageReceived implements MetricsCollector
+func (m *Metrics) MessageReceived(topic string, size int) {
+	locked(&m.lock, func() {
+		countTraffic(m.received, topic, size)
+	})
+}
+
+// MessagePublished implements MetricsCollector
+func (m *Metrics) MessagePublished(topic string, size int) {
+	locked(&m.lock, func() {
+		countTraffic(m.published, topic, size)
+	})
+}
+
+// PublishConfirmed implements MetricsCollector
+func (m *Metrics) PublishConfirmed(latency time.Duration) {
+	locked(&m.lock, func() {
+		m.confirmLatency.observe(latency)
+	})
+}
+
+// ConfirmTimedOut implements MetricsCollector
+func (m *Metrics) ConfirmTimedOut() {
+	locked(&m.lock, func() {
+		m.confirmTimeouts++
+	})
+}
+
+// Reconnected implements MetricsCollector
+func (m *Metrics) Reconnected() {
+	locked(&m.lock, func() {
+		m.reconnects++
+	})
+}
+
+// CallbackDone implements MetricsCollector
+func (m *Metrics) CallbackDone(_ string, duration time.Duration) {
+	locked(&m.lock, func() {
+		m.callbackDuration.observe(duration)
+	})
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	locked(&m.lock, func() {
+		writeTraffic(buf, "mqtt_messages_received_total", "Messages received from the broker.",
+			m.received, func(t *traffic) uint64 { return t.messages })
+		writeTraffic(buf, "mqtt_received_bytes_total", "Payload bytes received from the broker.",
+			m.received, func(t *traffic) uint64 { return t.bytes })
+		writeTraffic(buf, "mqtt_messages_published_total", "Messages published to the broker.",
+			m.published, func(t *traffic) uint64 { return t.messages })
+		writeTraffic(buf, "mqtt_published_bytes_total", "Payload bytes published to the broker.",
+			m.published, func(t *traffic) uint64 { return t.bytes })
+		writeHistogram(buf, "mqtt_publish_confirm_duration_seconds",
+			"Time until the broker confirmed a publication.", &m.confirmLatency)
+		writeCounter(buf, "mqtt_confirm_timeouts_total",
+			"Actions the broker did not confirm in time.", m.confirmTimeouts)
+		writeCounter(buf, "mqtt_reconnects_total",
+			"Connections established again after the connection was lost.", m.reconnects)
+		writeHistogram(buf, "mqtt_callback_duration_seconds",
+			"Execution time of subscription callbacks.", &m.callbackDuration)
+	})
+	return buf.Flush()
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounter(w io.Writer, name string, help string, value uint64) {
+	writeHeader(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func writeTraffic(w io.Writer, name string, help string, traffics map[string]*traffic, value func(*traffic) uint64) {
+	writeHeader(w, name, help, "counter")
+	topics := make([]string, 0, len(traffics))
+	for topic := range traffics {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	for _, topic := range topics {
+		fmt.Fprintf(w, "%s{topic=\"%s\"} %d\n", name, escapeLabel(topic), value(traffics[topic]))
+	}
+}
+
+func writeHistogram(w io.Writer, name string, help string, h *histogram) {
+	writeHeader(w, name, help, "histogram")
+	var cumulative uint64
+	for i, bound := range durationBuckets {
+		cumulative += h.buckets[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value for the Prometheus text format
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
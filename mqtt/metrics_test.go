@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// metricsText returns the metrics in the Prometheus text format
+func metricsText(t *testing.T, metrics *Metrics) string {
+	var buf bytes.Buffer
+	assert.Nil(t, metrics.WritePrometheus(&buf))
+	return buf.String()
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("Prometheus format", func(t *testing.T) {
+		metrics := NewMetrics()
+		metrics.MessageReceived("b", 3)
+		metrics.MessageReceived("a\"\\\n", 1)
+		metrics.MessageReceived("b", 2)
+		metrics.MessagePublished("c", 4)
+		metrics.PublishConfirmed(2 * time.Millisecond)
+		metrics.PublishConfirmed(2 * time.Second)
+		metrics.ConfirmTimedOut()
+		metrics.Reconnected()
+		metrics.CallbackDone("b", time.Millisecond)
+
+		text := metricsText(t, metrics)
+		for _, line := range []string{
+			"# TYPE mqtt_messages_received_total counter",
+			`mqtt_messages_received_total{topic="a\"\\\n"} 1`,
+			`mqtt_messages_received_total{topic="b"} 2`,
+			`mqtt_received_bytes_total{topic="b"} 5`,
+			`mqtt_messages_published_total{topic="c"} 1`,
+			`mqtt_published_bytes_total{topic="c"} 4`,
+			"# TYPE mqtt_publish_confirm_duration_seconds histogram",
+			`mqtt_publish_confirm_duration_seconds_bucket{le="0.001"} 0`,
+			`mqtt_publish_confirm_duration_seconds_bucket{le="0.005"} 1`,
+			`mqtt_publish_confirm_duration_seconds_bucket{le="1"} 1`,
+			`mqtt_publish_confirm_duration_seconds_bucket{le="5"} 2`,
+			`mqtt_publish_confirm_duration_seconds_bucket{le="+Inf"} 2`,
+			"mqtt_publish_confirm_duration_seconds_sum 2.002",
+			"mqtt_publish_confirm_duration_seconds_count 2",
+			"mqtt_confirm_timeouts_total 1",
+			"mqtt_reconnects_total 1",
+			`mqtt_callback_duration_seconds_bucket{le="0.001"} 1`,
+			"mqtt_callback_duration_seconds_count 1",
+		} {
+			assert.Contains(t, strings.Split(text, "\n"), line)
+		}
+	})
+
+	t.Run("Client", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		metrics := NewMetrics()
+		client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo(), WithMetrics(metrics))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		received := receiveMessages(t, client)
+		assert.Nil(t, client.PublishRaw(topic, 1, false, []byte("hello")))
+		expectMessage(t, received, "hello")
+
+		broker.dropConnections()
+		assert.Eventually(t, func() bool {
+			return len(broker.subscribed()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+
+		timeout := brokerConfirmTimeout
+		brokerConfirmTimeout = 50 * time.Millisecond
+		defer func() { brokerConfirmTimeout = timeout }()
+		broker.setAckPublish(false)
+		assert.ErrorIs(t, client.PublishRaw(topic, 1, false, []byte("lost")), ErrConfirmTimedOut)
+
+		// The broker forwards the unconfirmed message nevertheless
+		expectMessage(t, received, "lost")
+
+		lines := strings.Split(metricsText(t, metrics), "\n")
+		assert.Contains(t, lines, `mqtt_messages_published_total{topic="`+topic+`"} 2`)
+		assert.Contains(t, lines, `mqtt_published_bytes_total{topic="`+topic+`"} 9`)
+		assert.Contains(t, lines, `mqtt_received_bytes_total{topic="`+topic+`"} 9`)
+		assert.Contains(t, lines, "mqtt_publish_confirm_duration_seconds_count 1")
+		assert.Contains(t, lines, "mqtt_confirm_timeouts_total 1")
+		assert.Contains(t, lines, "mqtt_reconnects_total 1")
+		// The duration is reported after the callback returned
+		assert.Eventually(t, func() bool {
+			return strings.Contains(metricsText(t, metrics), "mqtt_callback_duration_seconds_count 2\n")
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/tq-systems/public-go-utils/v3/log"
+)
+
+/* A PublishFunc publishes a message. For outgoing messages, the QoS and Retain fields
+ * of the message are the parameters of the publication and Properties may only be set
+ * with WithProtocolV5.
+ */
+type PublishFunc func(ctx context.Context, message *Message) error
+
+/* A PublishMiddleware wraps the publication of messages, see WithPublishMiddleware. It
+ * returns a PublishFunc that may inspect or replace the message, e.g. to compress or
+ * sign the payload, before calling next, or return an error without calling next to
+ * drop the message.
+ */
+type PublishMiddleware func(next PublishFunc) PublishFunc
+
+/* A CallbackMiddleware wraps the callbacks of subscriptions, see WithCallbackMiddleware.
+ * It returns a MessageCallback that may inspect or replace the message before calling
+ * next, or skip next to drop the message.
+ */
+type CallbackMiddleware func(next MessageCallback) MessageCallback
+
+/* WithPublishMiddleware wraps all publications of the client (by PublishRaw and the
+ * other publish methods) with middlewares. The first middleware is the outermost one,
+ * i.e. it sees the message first. Using the option several times appends middlewares.
+ *
+ * Messages waiting in the offline queue have already passed the middlewares; the will
+ * and birth messages are published without them.
+ */
+func WithPublishMiddleware(middlewares ...PublishMiddleware) Option {
+	return func(opts *options) {
+		opts.publishMiddleware = append(opts.publishMiddleware, middlewares...)
+	}
+}
+
+/* WithCallbackMiddleware wraps the callbacks of all subscriptions of the client with
+ * middlewares. The first middleware is the outermost one, i.e. it sees the message first.
+ * Using the option several times appends middlewares.
+ *
+ * The middlewares run where the callbacks run: in the network thread of the backend
+ * or, with WithAsyncDispatch, in the goroutine of the subscription.
+ */
+func WithCallbackMiddleware(middlewares ...CallbackMiddleware) Option {
+	return func(opts *options) {
+		opts.callbackMiddleware = append(opts.callbackMiddleware, middlewares...)
+	}
+}
+
+// chainPublish returns publish wrapped with middlewares, the first one outermost
+func chainPublish(middlewares []PublishMiddleware, publish PublishFunc) PublishFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		publish = middlewares[i](publish)
+	}
+	return publish
+}
+
+// chainCallback returns callback wrapped with middlewares, the first one outermost
+func chainCallback(middlewares []CallbackMiddleware, callback MessageCallback) MessageCallback {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		callback = middlewares[i](callback)
+	}
+	return callback
+}
+
+/* recoverCallback is the built-in outermost CallbackMiddleware of every client. It
+ * logs a panic of a callback (or of another middleware) with its stack trace and drops
+ * the message, so that one bad callback does not crash the network thread of the
+ * backend.
+ */
+func recoverCallback(next MessageCallback) MessageCallback {
+	return func(message *Message) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("MQTT callback for topic %s panicked: %v\n%s", message.Topic, r, debug.Stack())
+			}
+		}()
+		next(message)
+	}
+}
+
+// wrapCallback returns callback wrapped with the callback middlewares of the client
+func (client *client) wrapCallback(callback MessageCallback) MessageCallback {
+	return chainCallback(client.callbackMiddleware, client.measureCallback(callback))
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// prefixPayload returns a PublishMiddleware prefixing the payload of messages
+func prefixPayload(prefix string) PublishMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, message *Message) error {
+			prefixed := *message
+			prefixed.Payload = append([]byte(prefix), message.Payload...)
+			return next(ctx, &prefixed)
+		}
+	}
+}
+
+// trimPayload returns a CallbackMiddleware removing a prefix from the payload of messages
+func trimPayload(prefix string) CallbackMiddleware {
+	return func(next MessageCallback) MessageCallback {
+		return func(message *Message) {
+			trimmed := *message
+			trimmed.Payload = []byte(strings.TrimPrefix(string(message.Payload), prefix))
+			next(&trimmed)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	errDropped := errors.New("dropped")
+	dropSecret := func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, message *Message) error {
+			if strings.HasPrefix(message.Topic, "secret/") {
+				return errDropped
+			}
+			return next(ctx, message)
+		}
+	}
+
+	broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+	client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo(),
+		WithPublishMiddleware(dropSecret, prefixPayload("a:")), WithPublishMiddleware(prefixPayload("b:")),
+		WithCallbackMiddleware(trimPayload("b:"), trimPayload("a:")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	t.Run("Publish and callback", func(t *testing.T) {
+		received := receiveMessages(t, client)
+		assert.Nil(t, client.PublishRaw(topic, 1, false, []byte("hello")))
+		expectMessage(t, received, "hello")
+		assert.Equal(t, []string{"b:a:hello"}, broker.publishedPayloads())
+
+		assert.ErrorIs(t, client.PublishRaw("secret/a", 1, false, []byte("hello")), errDropped)
+		assert.Len(t, broker.publishedPayloads(), 1)
+
+		err := client.PublishWithProperties(context.Background(), topic, 1, false, nil, &Properties{})
+		assert.ErrorIs(t, err, ErrProtocolV5Required)
+	})
+
+	t.Run("Panic recovery", func(t *testing.T) {
+		received := make(chan string, 10)
+		_, err := client.Subscribe("panic/+", func(topic string, message []byte) {
+			if topic == "panic/bad" {
+				panic("bad message")
+			}
+			received <- string(message)
+		})
+		assert.Nil(t, err)
+
+		assert.Nil(t, client.PublishRaw("panic/bad", 1, false, []byte("bad")))
+		assert.Nil(t, client.PublishRaw("panic/good", 1, false, []byte("good")))
+		expectMessage(t, received, "good")
+		assert.True(t, client.IsConnected())
+	})
+}
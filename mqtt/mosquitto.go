@@ -0,0 +1,516 @@
+//go:build cgo && !mqtt_purego
+
+/*
+ * Copyright (c) 2023-2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+//nolint:misspell
+package mqtt
+
+/*
+#cgo LDFLAGS: -lmosquitto
+#include <mosquitto.h>
+#include <stdlib.h>
+
+static void on_connect_cb(struct mosquitto *mosq, void *userdata, int result) {
+	void mosquittoOnConnect(struct mosquitto *mosq, int result);
+	mosquittoOnConnect(mosq, result);
+}
+
+static void on_disconnect_cb(struct mosquitto *mosq, void *userdata, int result) {
+	void mosquittoOnDisconnect(struct mosquitto *mosq, int result);
+	mosquittoOnDisconnect(mosq, result);
+}
+
+static void on_publish_cb(struct mosquitto *mosq, void *userdata, int mid) {
+	void mosquittoOnPubSub(struct mosquitto *mosq, int mid);
+	mosquittoOnPubSub(mosq, mid);
+}
+
+static void on_subscribe_cb(struct mosquitto *mosq, void *userdata, int mid, int qos_count, const int *granted_qos) {
+	void mosquittoOnPubSub(struct mosquitto *mosq, int mid);
+	mosquittoOnPubSub(mosq, mid);
+}
+
+static void on_unsubscribe_cb(struct mosquitto *mosq, void *userdata, int mid) {
+	void mosquittoOnPubSub(struct mosquitto *mosq, int mid);
+	mosquittoOnPubSub(mosq, mid);
+}
+
+static void on_message_cb(struct mosquitto *mosq, void *userdata, const struct mosquitto_message *msg,
+		const mosquitto_property *props) {
+	void mosquittoOnMessage(struct mosquitto *mosq, struct mosquitto_message *msg, mosquitto_property *props);
+	mosquittoOnMessage(mosq, (struct mosquitto_message *)msg, (mosquitto_property *)props);
+}
+
+static void setup_callbacks(struct mosquitto *mosq) {
+	mosquitto_connect_callback_set(mosq, on_connect_cb);
+	mosquitto_disconnect_callback_set(mosq, on_disconnect_cb);
+	mosquitto_publish_callback_set(mosq, on_publish_cb);
+	mosquitto_subscribe_callback_set(mosq, on_subscribe_cb);
+	mosquitto_unsubscribe_callback_set(mosq, on_unsubscribe_cb);
+	mosquitto_message_v5_callback_set(mosq, on_message_cb);
+}
+
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/tq-systems/public-go-utils/v3/log"
+)
+
+var (
+	initialize sync.Once
+	lock       sync.Mutex
+
+	// Global map to hold references to clients, and allow lookup from C callbacks
+	// Must only be accessed with lock held
+	clients = make(map[*C.struct_mosquitto]*client)
+)
+
+func init() {
+	newDefaultBackend = newMosquittoBackend
+}
+
+// mosquittoBackend connects a client using libmosquitto
+type mosquittoBackend struct {
+	mosq *C.struct_mosquitto
+
+	// brokers are tried in order when connecting initially
+	brokers   []BrokerAddress
+	keepalive time.Duration
+	// background keeps connecting after the first connection attempt failed
+	background bool
+}
+
+func newMosquittoBackend(client *client, brokerAddress string, brokerPort int, clientID string,
+	opts *options) (backend, error) {
+	initialize.Do(func() {
+		C.mosquitto_lib_init()
+	})
+
+	b := &mosquittoBackend{
+		brokers:    append([]BrokerAddress{{Address: brokerAddress, Port: brokerPort}}, opts.fallbackBrokers...),
+		keepalive:  opts.keepalive,
+		background: opts.background,
+	}
+
+	var cClientID *C.char
+	if clientID != "" {
+		cClientID = C.CString(clientID)
+		defer C.free(unsafe.Pointer(cClientID))
+	}
+	b.mosq = C.mosquitto_new(cClientID, C.bool(opts.cleanSession), nil)
+	if b.mosq == nil {
+		return nil, errors.New("unable to create MQTT client")
+	}
+
+	C.setup_callbacks(b.mosq)
+
+	if err := b.applyOptions(opts); err != nil {
+		C.mosquitto_destroy(b.mosq)
+		return nil, err
+	}
+
+	locked(&lock, func() {
+		clients[b.mosq] = client
+	})
+
+	return b, nil
+}
+
+func (b *mosquittoBackend) connect() error {
+	errs := make([]error, 0, len(b.brokers))
+	for _, broker := range b.brokers {
+		if ret := b.connectBroker(broker, false); ret != 0 {
+			errs = append(errs, fmt.Errorf("unable to connect to MQTT broker %s:%d: %w", broker.Address,
+				broker.Port, mosquittoError(ret)))
+			continue
+		}
+		C.mosquitto_loop_start(b.mosq)
+		return nil
+	}
+
+	err := errors.Join(errs...)
+	if !b.background {
+		return err
+	}
+	log.Warningf("%v; retrying in the background", err)
+	// The network thread keeps reconnecting to the preferred broker, even if this fails
+	b.connectBroker(b.brokers[0], true)
+	C.mosquitto_loop_start(b.mosq)
+
+	return nil
+}
+
+// connectBroker connects to broker, without waiting for the connection if async is set
+func (b *mosquittoBackend) connectBroker(broker BrokerAddress, async bool) C.int {
+	cBrokerAddress := C.CString(broker.Address)
+	defer C.free(unsafe.Pointer(cBrokerAddress))
+	keepalive := C.int(b.keepalive / time.Second)
+	if async {
+		return C.mosquitto_connect_async(b.mosq, cBrokerAddress, C.int(broker.Port), keepalive)
+	}
+	return C.mosquitto_connect(b.mosq, cBrokerAddress, C.int(broker.Port), keepalive)
+}
+
+// cStrings copies strs to a C array of strings, which must be released with freeCStrings
+func cStrings(strs []string) **C.char {
+	array := (**C.char)(C.malloc(C.size_t(len(strs)) * C.size_t(unsafe.Sizeof((*C.char)(nil)))))
+	for i, str := range strs {
+		unsafe.Slice(array, len(strs))[i] = C.CString(str)
+	}
+	return array
+}
+
+func freeCStrings(array **C.char, count int) {
+	for _, str := range unsafe.Slice(array, count) {
+		C.free(unsafe.Pointer(str))
+	}
+	C.free(unsafe.Pointer(array))
+}
+
+func (b *mosquittoBackend) subscribe(topics []string, opts SubscribeOptions, id int) (int, error) {
+	cTopics := cStrings(topics)
+	defer freeCStrings(cTopics, len(topics))
+
+	var cProperties *C.mosquitto_property
+	if id != 0 {
+		if ret := C.mosquitto_property_add_varint(&cProperties, C.MQTT_PROP_SUBSCRIPTION_IDENTIFIER,
+			C.uint32_t(id)); ret != 0 {
+			return 0, fmt.Errorf("unable to set subscription identifier: %w", mosquittoError(ret))
+		}
+		defer C.mosquitto_property_free_all(&cProperties)
+	}
+
+	var mid C.int
+	ret := C.mosquitto_subscribe_multiple(b.mosq, &mid, C.int(len(topics)), cTopics, C.int(opts.QoS),
+		C.int(opts.bits()), cProperties)
+	if ret != 0 {
+		return 0, mosquittoError(ret)
+	}
+	return int(mid), nil
+}
+
+func (b *mosquittoBackend) unsubscribe(topics []string) (int, error) {
+	cTopics := cStrings(topics)
+	defer freeCStrings(cTopics, len(topics))
+
+	var mid C.int
+	if ret := C.mosquitto_unsubscribe_multiple(b.mosq, &mid, C.int(len(topics)), cTopics, nil); ret != 0 {
+		return 0, mosquittoError(ret)
+	}
+	return int(mid), nil
+}
+
+func (b *mosquittoBackend) publish(topic string, qos byte, retain bool, payload []byte,
+	properties *Properties) (int, error) {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	cProperties, err := newMosquittoProperties(properties)
+	if err != nil {
+		return 0, err
+	}
+	defer C.mosquitto_property_free_all(&cProperties)
+
+	var ptr unsafe.Pointer
+	if len(payload) > 0 {
+		ptr = unsafe.Pointer(&payload[0])
+	}
+
+	var mid C.int
+	ret := C.mosquitto_publish_v5(b.mosq, &mid, cTopic, C.int(len(payload)), ptr, C.int(qos), C.bool(retain),
+		cProperties)
+	if ret != 0 {
+		return 0, mosquittoError(ret)
+	}
+	return int(mid), nil
+}
+
+func (b *mosquittoBackend) disconnect() {
+	C.mosquitto_disconnect(b.mosq)
+}
+
+func (b *mosquittoBackend) stop() {
+	C.mosquitto_loop_stop(b.mosq, C.bool(true))
+	C.mosquitto_destroy(b.mosq)
+
+	locked(&lock, func() {
+		delete(clients, b.mosq)
+	})
+}
+
+// applyOptions configures b.mosq according to opts before connecting
+func (b *mosquittoBackend) applyOptions(opts *options) error {
+	reconnectDelay := max(opts.reconnectDelay/time.Second, 1)
+	maxReconnectDelay := max(opts.maxReconnectDelay/time.Second, reconnectDelay)
+	ret := C.mosquitto_reconnect_delay_set(b.mosq, C.uint(reconnectDelay), C.uint(maxReconnectDelay),
+		C.bool(maxReconnectDelay > reconnectDelay))
+	if ret != 0 {
+		return fmt.Errorf("unable to set MQTT reconnect delay: %w", mosquittoError(ret))
+	}
+
+	if opts.protocolV5 {
+		if ret := C.mosquitto_int_option(b.mosq, C.MOSQ_OPT_PROTOCOL_VERSION, C.MQTT_PROTOCOL_V5); ret != 0 {
+			return fmt.Errorf("unable to select MQTT v5: %w", mosquittoError(ret))
+		}
+	}
+
+	if opts.username != "" || opts.password != "" {
+		cUsername := C.CString(opts.username)
+		defer C.free(unsafe.Pointer(cUsername))
+		cPassword := C.CString(opts.password)
+		defer C.free(unsafe.Pointer(cPassword))
+		if ret := C.mosquitto_username_pw_set(b.mosq, cUsername, cPassword); ret != 0 {
+			return fmt.Errorf("unable to set MQTT credentials: %w", mosquittoError(ret))
+		}
+	}
+
+	if opts.tls != nil {
+		cCAFile, cCAPath := optionalCString(opts.tls.CAFile), optionalCString(opts.tls.CAPath)
+		defer C.free(unsafe.Pointer(cCAFile))
+		defer C.free(unsafe.Pointer(cCAPath))
+		cCertFile, cKeyFile := optionalCString(opts.tls.CertFile), optionalCString(opts.tls.KeyFile)
+		defer C.free(unsafe.Pointer(cCertFile))
+		defer C.free(unsafe.Pointer(cKeyFile))
+		if ret := C.mosquitto_tls_set(b.mosq, cCAFile, cCAPath, cCertFile, cKeyFile, nil); ret != 0 {
+			return fmt.Errorf("unable to set up MQTT TLS: %w", mosquittoError(ret))
+		}
+
+		if opts.tls.Version != "" || opts.tls.Ciphers != "" {
+			cVersion, cCiphers := optionalCString(opts.tls.Version), optionalCString(opts.tls.Ciphers)
+			defer C.free(unsafe.Pointer(cVersion))
+			defer C.free(unsafe.Pointer(cCiphers))
+			// cert_reqs 1 corresponds to SSL_VERIFY_PEER, the only sensible choice for clients
+			if ret := C.mosquitto_tls_opts_set(b.mosq, 1, cVersion, cCiphers); ret != 0 {
+				return fmt.Errorf("unable to set MQTT TLS options: %w", mosquittoError(ret))
+			}
+		}
+
+		if ret := C.mosquitto_tls_insecure_set(b.mosq, C.bool(opts.tls.Insecure)); ret != 0 {
+			return fmt.Errorf("unable to set MQTT TLS hostname verification: %w", mosquittoError(ret))
+		}
+	}
+
+	if opts.will != nil {
+		cTopic := C.CString(opts.will.topic)
+		defer C.free(unsafe.Pointer(cTopic))
+		var payload unsafe.Pointer
+		if len(opts.will.payload) > 0 {
+			payload = C.CBytes(opts.will.payload)
+			defer C.free(payload)
+		}
+		ret := C.mosquitto_will_set(b.mosq, cTopic, C.int(len(opts.will.payload)), payload,
+			C.int(opts.will.qos), C.bool(opts.will.retain))
+		if ret != 0 {
+			return fmt.Errorf("unable to set MQTT will: %w", mosquittoError(ret))
+		}
+	}
+
+	if opts.psk != nil {
+		cPSK, cIdentity := C.CString(opts.psk.PSK), C.CString(opts.psk.Identity)
+		defer C.free(unsafe.Pointer(cPSK))
+		defer C.free(unsafe.Pointer(cIdentity))
+		cCiphers := optionalCString(opts.psk.Ciphers)
+		defer C.free(unsafe.Pointer(cCiphers))
+		if ret := C.mosquitto_tls_psk_set(b.mosq, cPSK, cIdentity, cCiphers); ret != 0 {
+			return fmt.Errorf("unable to set up MQTT TLS-PSK: %w", mosquittoError(ret))
+		}
+	}
+
+	return nil
+}
+
+// optionalCString converts s to a C string, mapping "" to NULL. The result
+// must be freed by the caller (freeing NULL is a no-op).
+func optionalCString(s string) *C.char {
+	if s == "" {
+		return nil
+	}
+	return C.CString(s)
+}
+
+// mosquittoError converts a libmosquitto error number to an error
+func mosquittoError(errno C.int) error {
+	return errors.New(C.GoString(C.mosquitto_strerror(errno)))
+}
+
+// newMosquittoProperties converts props to a libmosquitto property list, which must be
+// freed with mosquitto_property_free_all. A nil list is returned for nil props.
+func newMosquittoProperties(props *Properties) (*C.mosquitto_property, error) {
+	var list *C.mosquitto_property
+	if props == nil {
+		return list, nil
+	}
+
+	addString := func(identifier C.int, value string) C.int {
+		cValue := C.CString(value)
+		defer C.free(unsafe.Pointer(cValue))
+		return C.mosquitto_property_add_string(&list, identifier, cValue)
+	}
+
+	var ret C.int
+	if props.ContentType != "" {
+		ret = addString(C.MQTT_PROP_CONTENT_TYPE, props.ContentType)
+	}
+	if ret == 0 && props.ResponseTopic != "" {
+		ret = addString(C.MQTT_PROP_RESPONSE_TOPIC, props.ResponseTopic)
+	}
+	if ret == 0 && len(props.CorrelationData) > 0 {
+		data := C.CBytes(props.CorrelationData)
+		ret = C.mosquitto_property_add_binary(&list, C.MQTT_PROP_CORRELATION_DATA, data,
+			C.uint16_t(len(props.CorrelationData)))
+		C.free(data)
+	}
+	if ret == 0 && props.MessageExpiry > 0 {
+		ret = C.mosquitto_property_add_int32(&list, C.MQTT_PROP_MESSAGE_EXPIRY_INTERVAL,
+			C.uint32_t(props.messageExpirySeconds()))
+	}
+	for _, prop := range props.UserProperties {
+		if ret != 0 {
+			break
+		}
+		cKey, cValue := C.CString(prop.Key), C.CString(prop.Value)
+		ret = C.mosquitto_property_add_string_pair(&list, C.MQTT_PROP_USER_PROPERTY, cKey, cValue)
+		C.free(unsafe.Pointer(cKey))
+		C.free(unsafe.Pointer(cValue))
+	}
+
+	if ret != 0 {
+		C.mosquitto_property_free_all(&list)
+		return nil, fmt.Errorf("unable to set MQTT v5 properties: %w", mosquittoError(ret))
+	}
+
+	return list, nil
+}
+
+// goProperties converts a libmosquitto property list to Properties, returning nil
+// if the list does not contain any of the supported properties
+func goProperties(list *C.mosquitto_property) *Properties {
+	props := &Properties{}
+	found := false
+
+	for prop := list; prop != nil; prop = C.mosquitto_property_next(prop) {
+		switch identifier := C.mosquitto_property_identifier(prop); identifier {
+		case C.MQTT_PROP_CONTENT_TYPE, C.MQTT_PROP_RESPONSE_TOPIC:
+			var value *C.char
+			if C.mosquitto_property_read_string(prop, identifier, &value, false) == nil {
+				continue
+			}
+			if identifier == C.MQTT_PROP_CONTENT_TYPE {
+				props.ContentType = C.GoString(value)
+			} else {
+				props.ResponseTopic = C.GoString(value)
+			}
+			C.free(unsafe.Pointer(value))
+		case C.MQTT_PROP_CORRELATION_DATA:
+			var value unsafe.Pointer
+			var length C.uint16_t
+			if C.mosquitto_property_read_binary(prop, identifier, &value, &length, false) == nil {
+				continue
+			}
+			props.CorrelationData = C.GoBytes(value, C.int(length))
+			C.free(value)
+		case C.MQTT_PROP_MESSAGE_EXPIRY_INTERVAL:
+			var value C.uint32_t
+			if C.mosquitto_property_read_int32(prop, identifier, &value, false) == nil {
+				continue
+			}
+			props.MessageExpiry = time.Duration(value) * time.Second
+		case C.MQTT_PROP_USER_PROPERTY:
+			var key, value *C.char
+			if C.mosquitto_property_read_string_pair(prop, identifier, &key, &value, false) == nil {
+				continue
+			}
+			props.UserProperties = append(props.UserProperties, UserProperty{
+				Key:   C.GoString(key),
+				Value: C.GoString(value),
+			})
+			C.free(unsafe.Pointer(key))
+			C.free(unsafe.Pointer(value))
+		default:
+			continue
+		}
+		found = true
+	}
+
+	if !found {
+		return nil
+	}
+	return props
+}
+
+// subscriptionIDs returns the subscription identifiers in a libmosquitto property list
+func subscriptionIDs(list *C.mosquitto_property) []int {
+	var ids []int
+	for prop := list; prop != nil; prop = C.mosquitto_property_next(prop) {
+		var value C.uint32_t
+		if C.mosquitto_property_identifier(prop) == C.MQTT_PROP_SUBSCRIPTION_IDENTIFIER &&
+			C.mosquitto_property_read_varint(prop, C.MQTT_PROP_SUBSCRIPTION_IDENTIFIER, &value, false) != nil {
+			ids = append(ids, int(value))
+		}
+	}
+	return ids
+}
+
+func getClient(client *C.struct_mosquitto) *client {
+	lock.Lock()
+	defer lock.Unlock()
+	return clients[client]
+}
+
+//export mosquittoOnConnect
+func mosquittoOnConnect(mosq *C.struct_mosquitto, result C.int) {
+	var err error
+	if result != 0 {
+		err = errors.New(C.GoString(C.mosquitto_connack_string(result)))
+	}
+	getClient(mosq).onConnect(err)
+}
+
+//export mosquittoOnDisconnect
+func mosquittoOnDisconnect(mosq *C.struct_mosquitto, result C.int) {
+	var err error
+	if result != 0 {
+		err = errors.New(C.GoString(C.mosquitto_strerror(result)))
+	}
+	getClient(mosq).onDisconnect(int(result), err)
+}
+
+//export mosquittoOnPubSub
+func mosquittoOnPubSub(mosq *C.struct_mosquitto, mid C.int) {
+	getClient(mosq).onPubSub(int(mid))
+}
+
+// mosquittoOnMessage converts a message received by libmosquitto. props holds the
+// MQTT v5 properties of the message, it is nil for MQTT 3.1.1.
+//
+//export mosquittoOnMessage
+func mosquittoOnMessage(mosq *C.struct_mosquitto, message *C.struct_mosquitto_message, props *C.mosquitto_property) {
+	getClient(mosq).onMessage(&Message{
+		Topic:      C.GoString(message.topic),
+		Payload:    C.GoBytes(message.payload, message.payloadlen),
+		Properties: goProperties(props),
+		QoS:        byte(message.qos),
+		Retain:     bool(message.retain),
+		MessageID:  int(message.mid),
+
+		subscriptionIDs: subscriptionIDs(props),
+	})
+}
@@ -18,53 +18,14 @@
 //nolint:misspell
 package mqtt
 
-/*
-#cgo LDFLAGS: -lmosquitto
-#include <mosquitto.h>
-#include <stdlib.h>
-
-static void on_connect_cb(struct mosquitto *mosq, void *userdata, int result) {
-	void onConnect(struct mosquitto *mosq);
-	onConnect(mosq);
-}
-
-static void on_disconnect_cb(struct mosquitto *mosq, void *userdata, int result) {
-	void onDisconnect(struct mosquitto *mosq);
-	onDisconnect(mosq);
-}
-
-static void on_publish_cb(struct mosquitto *mosq, void *userdata, int mid) {
-	void onPubSub(struct mosquitto *mosq, int mid);
-	onPubSub(mosq, mid);
-}
-
-static void on_subscribe_cb(struct mosquitto *mosq, void *userdata, int mid, int qos_count, const int *granted_qos) {
-	void onPubSub(struct mosquitto *mosq, int mid);
-	onPubSub(mosq, mid);
-}
-
-static void on_message_cb(struct mosquitto *mosq, void *userdata, const struct mosquitto_message *msg) {
-	void onMessage(struct mosquitto *mosq, struct mosquitto_message *msg);
-	onMessage(mosq, (struct mosquitto_message *)msg);
-}
-
-static void setup_callbacks(struct mosquitto *mosq) {
-	mosquitto_connect_callback_set(mosq, on_connect_cb);
-	mosquitto_disconnect_callback_set(mosq, on_disconnect_cb);
-	mosquitto_publish_callback_set(mosq, on_publish_cb);
-	mosquitto_subscribe_callback_set(mosq, on_subscribe_cb);
-	mosquitto_message_callback_set(mosq, on_message_cb);
-}
-
-*/
-import "C"
-
 import (
+	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
-	"unsafe"
 
 	"github.com/tq-systems/public-go-utils/v3/log"
 
@@ -74,70 +35,170 @@ import (
 // A Callback is a function run for every message received for a subscribed topic
 type Callback func(topic string, message []byte)
 
+// topicSubscription is the broker subscription of a topic, shared by all subscriptions for it
+type topicSubscription struct {
+	refs    int
+	options SubscribeOptions
+	// id is the MQTT v5 subscription identifier of the topic, see newSubscriptionID
+	id int
+	// The request making the broker subscription, nil once it is confirmed
+	pending *subscribeResult
+}
+
+// A subscribeResult tells the subscriptions of a topic whether the pending broker
+// subscription succeeded
+type subscribeResult struct {
+	topics []string
+	// The SUBSCRIBE request, confirmed is nil if none was sent
+	mid       int
+	confirmed chan error
+	// waiters counts the subscribe calls waiting for the result. Guarded by currentMsgLock.
+	waiters int
+
+	done chan struct{}
+	// Set before done is closed
+	err error
+}
+
 type subscription struct {
-	client   *client
-	topic    string
-	callback Callback
+	*dispatcher
+	client *client
+	topic  string
 }
 
 type client struct {
-	mosq             *C.struct_mosquitto
+	backend          backend
 	subscriptions    map[*subscription]bool
-	subscribedTopics map[string]int
-
-	connected     bool
+	subscribedTopics map[string]*topicSubscription
+	// Indexes subscriptions by their topic for incoming messages
+	subscriptionIndex *topicTrie[*subscription]
+
+	// Published after every (re)connect if set
+	birth *publication
+	// Queue settings for each subscription, nil for synchronous callbacks
+	dispatch *dispatchOptions
+	// Whether the connection uses MQTT v5
+	protocolV5 bool
+	// Receives the activity of the client, nil if metrics are not collected
+	metrics MetricsCollector
+	// Publishes messages through the publish middlewares
+	publishChain PublishFunc
+	// Wrap the callbacks of subscriptions, starting with recoverCallback
+	callbackMiddleware []CallbackMiddleware
+
+	connected bool
+	// Set by Close to tell a closed connection from a lost one
+	closing       bool
 	connectedCond *sync.Cond
+	// Error of the last connection attempt refused by the broker, nil if none
+	refusedErr error
+	// Timestamps and reason of the last connection changes, reported to watchers
+	state    ConnectionState
+	watchers map[*connectionWatcher]bool
 
 	// Synchronizes accesses to the subscriptions maps and the connected condition
 	lock *sync.Mutex
 
-	/* Synchronizes subscribe/unsubscribe or robust publish calls
+	/* Synchronizes calls of the backend and changes of the subscriptions
 	 *
-	 * While we do not support calling Subscribe/Unsubscribe from
-	 * different goroutines, there may be explicit subscriptions
-	 * running at the same time as resubscriptions due to automatic
-	 * reconnect. doSubLock ensures that only one such call is
-	 * running at a time.
+	 * Subscribe and Unsubscribe may be called from different goroutines
+	 * at the same time, and there may be resubscriptions due to automatic
+	 * reconnect. The subscriptions maps are only changed while holding
+	 * currentMsgLock (and lock, which must be locked second), and the
+	 * resulting request is sent to the broker before currentMsgLock is
+	 * released. So the broker receives SUBSCRIBE and UNSUBSCRIBE requests
+	 * in the order of the changes, e.g. when the last subscription of a
+	 * topic is removed while a new one is added.
 	 *
 	 * Explicit subscriptions always wait for the subscription to
 	 * finish. This is done by storing channels for such subscriptions
 	 * in confirmWaiters and blocking until the channel is closed. This
-	 * happens when the subscription is confirmed in onPubSub or
-	 * brokerConfirmTimeout has passed.
+	 * happens when the subscription is confirmed in onPubSub. If the
+	 * caller's context is done (or brokerConfirmTimeout has passed)
+	 * before, the channel is removed from confirmWaiters again.
 	 *
 	 * The same facilities are used for robust publish calls (publish with
 	 * QoS >= 1) to wait for the publish to succeed.
 	 */
 	currentMsgLock *sync.Mutex
-	confirmWaiters map[C.int]chan error
+	confirmWaiters map[int]chan error
+	// Set by Close before stopping the backend, which must not be used anymore then.
+	// Accesses must hold currentMsgLock.
+	backendStopped bool
+	// Set by onConnect before restoring the subscriptions and cleared by onDisconnect;
+	// subscriptions made while it is unset are only restored. Accesses must hold
+	// currentMsgLock.
+	sessionUp bool
+	// Topics unsubscribed while the session was down, which the broker keeps without
+	// clean session; onConnect unsubscribes them unless subscribed again. Accesses must
+	// hold currentMsgLock.
+	pendingUnsubscribes map[string]bool
+	cleanSession        bool
+	// The subscription identifier of the last shared subscription, see newSubscriptionID.
+	// Accesses must hold lock.
+	lastSubscriptionID int
+
+	// Publications made while disconnected, nil unless WithOfflineQueue is used.
+	// Accesses must hold lock.
+	queue *offlineQueue
+	// Stops and tracks the goroutine flushing queue
+	stopFlusher context.CancelFunc
+	flusher     sync.WaitGroup
 }
 
 // A Subscription tracks a registered subscription and can be used to unsubscribe
 type Subscription interface {
 	Unsubscribe()
+	UnsubscribeContext(ctx context.Context) error
+	// Dropped returns the number of messages discarded because the queue of
+	// the subscription was full (see WithAsyncDispatch)
+	Dropped() uint64
 }
 
 // A Client represents a connection to an MQTT broker
 type Client interface {
-	Subscribe(topic string, callback Callback) (Subscription, error)
+	Subscribe(topic string, callback Callback, opts ...SubscribeOption) (Subscription, error)
+	SubscribeContext(ctx context.Context, topic string, callback Callback, opts ...SubscribeOption) (Subscription, error)
+	SubscribeMessage(ctx context.Context, topic string, callback MessageCallback, opts ...SubscribeOption) (Subscription, error)
+	SubscribeMany(ctx context.Context, requests []SubscriptionRequest) ([]Subscription, error)
+	UnsubscribeMany(ctx context.Context, subscriptions []Subscription) error
+	GetRetained(ctx context.Context, topic string) (*Message, error)
+	GetRetainedPattern(ctx context.Context, filter string) ([]*Message, error)
+	ClearRetained(ctx context.Context, filter string) error
 	PublishRaw(topic string, qos byte, retain bool, message []byte) error
+	PublishRawContext(ctx context.Context, topic string, qos byte, retain bool, message []byte) error
+	PublishWithProperties(ctx context.Context, topic string, qos byte, retain bool, message []byte,
+		properties *Properties) error
 	PublishEmpty(topic string, qos byte, retain bool) error
 	Publish(topic string, qos byte, retain bool, message proto.Message) error
+	PublishContext(ctx context.Context, topic string, qos byte, retain bool, message proto.Message) error
+	IsConnected() bool
+	ConnectionState() ConnectionState
+	OnConnectionChange(callback ConnectionCallback) func()
 	Close()
 }
 
 var (
-	initialize sync.Once
-	lock       sync.Mutex
-
 	// ErrConfirmTimedOut indicates that the MQTT broker did not confirm an
-	// action within brokerConfirmTimeout.
+	// action within brokerConfirmTimeout. It is only returned if the caller
+	// did not set a deadline on the context of the action.
 	ErrConfirmTimedOut   = fmt.Errorf("waiting for confirmation from the broker timed out")
 	brokerConfirmTimeout = 5 * time.Second
+	// ErrClientClosed is returned for actions on a closed client, including
+	// actions still waiting for a confirmation when the client was closed
+	ErrClientClosed = errors.New("MQTT client closed")
+	// ErrNotSubscribed is returned when unsubscribing a subscription a second time
+	ErrNotSubscribed = errors.New("MQTT subscription already removed")
+	// errConfirmLost is returned for actions whose confirmation was lost with the connection
+	errConfirmLost = errors.New("connection lost before the MQTT broker confirmed the action")
+)
 
-	// Global map to hold references to clients, and allow lookup from C callbacks
-	// Must only be accessed with lock held
-	clients = make(map[*C.struct_mosquitto]*client)
+const (
+	// nonSharedSubscriptionID is the MQTT v5 subscription identifier of all broker
+	// subscriptions except shared ones
+	nonSharedSubscriptionID = 1
+	// maxSubscriptionID is the largest subscription identifier allowed by MQTT v5
+	maxSubscriptionID = 268435455
 )
 
 func locked(m *sync.Mutex, f func()) {
@@ -146,113 +207,238 @@ func locked(m *sync.Mutex, f func()) {
 	f()
 }
 
-// NewClient opens a new connection to an MQTT broker
+// NewClient opens a new connection to an MQTT broker using the default options
 func NewClient(brokerAddress string, brokerPort int, clientID string) (Client, error) {
-	initialize.Do(func() {
-		C.mosquitto_lib_init()
-	})
+	return NewClientWithOptions(brokerAddress, brokerPort, clientID)
+}
+
+// NewClientWithOptions opens a new connection to an MQTT broker, configured by opts
+func NewClientWithOptions(brokerAddress string, brokerPort int, clientID string, opts ...Option) (Client, error) {
+	return NewClientContext(context.Background(), brokerAddress, brokerPort, clientID, opts...)
+}
+
+// NewClientContext opens a new connection to an MQTT broker, configured by opts.
+// If ctx is done before the broker acknowledged the connection, the connection
+// attempt is aborted and the context's error is returned. With WithBackgroundConnect,
+// it returns without waiting for the connection.
+func NewClientContext(ctx context.Context, brokerAddress string, brokerPort int, clientID string,
+	opts ...Option) (Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	clientOpts := defaultOptions()
+	for _, opt := range opts {
+		opt(clientOpts)
+	}
+	if err := clientOpts.validate(); err != nil {
+		return nil, fmt.Errorf("invalid MQTT client options: %w", err)
+	}
+	if !clientOpts.cleanSession && clientID == "" {
+		return nil, errors.New("invalid MQTT client options: persistent sessions require a client ID")
+	}
 
 	client := &client{
-		subscriptions:    make(map[*subscription]bool),
-		subscribedTopics: make(map[string]int),
-		lock:             &sync.Mutex{},
-		connectedCond:    &sync.Cond{},
-		currentMsgLock:   &sync.Mutex{},
-		confirmWaiters:   make(map[C.int]chan error),
+		subscriptions:       make(map[*subscription]bool),
+		subscribedTopics:    make(map[string]*topicSubscription),
+		pendingUnsubscribes: make(map[string]bool),
+		subscriptionIndex:   newTopicTrie[*subscription](),
+		lock:                &sync.Mutex{},
+		connectedCond:       &sync.Cond{},
+		currentMsgLock:      &sync.Mutex{},
+		confirmWaiters:      make(map[int]chan error),
+		watchers:            make(map[*connectionWatcher]bool),
+		birth:               clientOpts.birth,
+		dispatch:            clientOpts.dispatch,
+		protocolV5:          clientOpts.protocolV5,
+		cleanSession:        clientOpts.cleanSession,
+		metrics:             clientOpts.metrics,
 	}
 	client.connectedCond.L = client.lock
+	client.publishChain = chainPublish(clientOpts.publishMiddleware, client.publishMessage)
+	client.callbackMiddleware = append([]CallbackMiddleware{recoverCallback}, clientOpts.callbackMiddleware...)
 
-	cClientID := C.CString(clientID)
-	defer C.free(unsafe.Pointer(cClientID))
-	client.mosq = C.mosquitto_new(cClientID, true, nil)
+	if clientOpts.offlineQueue != nil {
+		queue, err := newOfflineQueue(*clientOpts.offlineQueue)
+		if err != nil {
+			log.Error(fmt.Sprintf("Unable to open MQTT offline queue: %v", err))
+			return nil, err
+		}
+		client.queue = queue
+	}
 
-	C.setup_callbacks(client.mosq)
+	newBackend := newDefaultBackend
+	if clientOpts.pureGo {
+		newBackend = newGoBackend
+	}
+	backend, err := newBackend(client, brokerAddress, brokerPort, clientID, clientOpts)
+	if err != nil {
+		log.Error(fmt.Sprintf("Unable to configure MQTT client: %v", err))
+		client.queue.close()
+		return nil, err
+	}
+	client.backend = backend
 
-	cBrokerAddress := C.CString(brokerAddress)
-	defer C.free(unsafe.Pointer(cBrokerAddress))
-	if C.mosquitto_connect(client.mosq, cBrokerAddress, C.int(brokerPort), 10) != 0 {
+	if err := backend.connect(); err != nil {
 		log.Error(fmt.Sprintf("Unable to connect to MQTT broker %s:%d", brokerAddress, brokerPort))
-		C.mosquitto_destroy(client.mosq)
-		return nil, fmt.Errorf("unable to connect to MQTT broker %s:%d", brokerAddress, brokerPort)
+		backend.stop()
+		client.queue.close()
+		return nil, err
 	}
 
-	locked(&lock, func() {
-		clients[client.mosq] = client
-	})
+	if !clientOpts.background {
+		if err := client.waitConnected(ctx, brokerAddress, brokerPort); err != nil {
+			return nil, err
+		}
+	}
+
+	if client.queue != nil {
+		var flusherCtx context.Context
+		flusherCtx, client.stopFlusher = context.WithCancel(context.Background())
+		client.flusher.Add(1)
+		go client.flushOfflineQueue(flusherCtx)
+	}
 
-	C.mosquitto_loop_start(client.mosq)
+	return client, nil
+}
 
+// waitConnected waits for the first connection to the broker. If the connection fails,
+// the client is closed.
+func (client *client) waitConnected(ctx context.Context, brokerAddress string, brokerPort int) error {
+	// Wake up the wait loop below when ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		locked(client.lock, func() {
+			client.connectedCond.Broadcast()
+		})
+	})
+	defer stop()
+
+	var err error
 	locked(client.lock, func() {
-		for !client.connected {
+		for !client.connected && client.refusedErr == nil && ctx.Err() == nil {
 			client.connectedCond.Wait()
 		}
+		switch {
+		case client.connected:
+		case client.refusedErr != nil:
+			err = fmt.Errorf("MQTT broker %s:%d refused the connection: %w", brokerAddress, brokerPort,
+				client.refusedErr)
+		default:
+			err = ctx.Err()
+		}
 	})
 
-	return client, nil
-}
-
-func getClient(client *C.struct_mosquitto) *client {
-	lock.Lock()
-	defer lock.Unlock()
-	return clients[client]
+	if err != nil {
+		log.Error(fmt.Sprintf("Unable to connect to MQTT broker %s:%d: %v", brokerAddress, brokerPort, err))
+		client.Close()
+	}
+	return err
 }
 
 /* onConnect updates the "connected" field of a client and ensures that subscriptions are
- * restored after an automatic reconnect to the MQTT broker.
+ * restored after an automatic reconnect to the MQTT broker. Afterwards, the birth message
+ * is published if configured. If the broker refused the connection (refused != nil), the
+ * error is recorded for NewClientContext instead.
  */
-//export onConnect
-func onConnect(mosq *C.struct_mosquitto) {
+func (client *client) onConnect(refused error) {
+	if refused != nil {
+		log.Errorf("MQTT connection refused: %v", refused)
+		locked(client.lock, func() {
+			client.refusedErr = refused
+			client.connectedCond.Broadcast()
+		})
+		return
+	}
+
 	log.Debug("MQTT connection established")
 
-	client := getClient(mosq)
+	// Topics with the same options are restored with a single request
+	locked(client.currentMsgLock, func() {
+		client.sessionUp = true
+		var groups []*topicGroup
+		unsubscribe := make([]string, 0)
+		locked(client.lock, func() {
+			for topic, topicSub := range client.subscribedTopics {
+				groups = groupTopic(groups, topic, topicSub.options, topicSub.id)
+			}
+			for topic := range client.pendingUnsubscribes {
+				if _, ok := client.subscribedTopics[topic]; !ok {
+					unsubscribe = append(unsubscribe, topic)
+				}
+			}
+		})
+		clear(client.pendingUnsubscribes)
 
-	topics := make([]string, 0)
+		if len(unsubscribe) > 0 {
+			if _, _, err := client.sendUnsubscribe(unsubscribe, false); err != nil {
+				log.Errorf("failed to unsubscribe from topics %s: %v", strings.Join(unsubscribe, ", "), err)
+			}
+		}
 
-	locked(client.lock, func() {
-		for topic := range client.subscribedTopics {
-			topics = append(topics, topic)
+		for _, group := range groups {
+			if _, _, err := client.sendSubscribe(group.topics, group.opts, group.id, false); err != nil {
+				log.Errorf("failed to subscribe to topics %s: %v", strings.Join(group.topics, ", "), err)
+			}
 		}
 	})
 
-	for _, topic := range topics {
-		err := client.doSubscribe(topic, false)
+	if client.birth != nil {
+		// Waiting for a confirmation would block the network thread of the backend
+		err := client.doPublish(context.Background(), client.birth.topic, client.birth.qos,
+			client.birth.retain, client.birth.payload, nil, false)
 		if err != nil {
-			log.Errorf("failed to subscribe to topic %s: %v", topic, err)
+			log.Errorf("failed to publish birth message to topic %s: %v", client.birth.topic, err)
 		}
 	}
 
+	var callbacks []ConnectionCallback
+	reconnected := false
+	event := ConnectionEvent{Connected: true, Time: time.Now()}
 	locked(client.lock, func() {
-		client.connected = true
+		reconnected = !client.state.LastConnect.IsZero()
+		callbacks = client.updateConnectionState(event)
+		client.refusedErr = nil
 		client.connectedCond.Broadcast()
 	})
+	if reconnected && client.metrics != nil {
+		client.metrics.Reconnected()
+	}
+	for _, callback := range callbacks {
+		callback(event)
+	}
 }
 
-/* onDisconnect updates the "connected" field of a client. A warning
- * message is printed if the disconnect is unexpetected (not caused by
- * our own Close() call)
+/* onDisconnect updates the "connected" field of a client and notifies the
+ * connection watchers. A warning message is printed if the disconnect is
+ * unexpetected (not caused by our own Close() call)
  */
-//export onDisconnect
-func onDisconnect(mosq *C.struct_mosquitto) {
-	client := getClient(mosq)
+func (client *client) onDisconnect(reason int, err error) {
+	locked(client.currentMsgLock, func() {
+		client.sessionUp = false
+	})
 
+	var callbacks []ConnectionCallback
+	event := ConnectionEvent{Connected: false, Time: time.Now(), Reason: reason, Err: err}
 	locked(client.lock, func() {
-		if client.mosq != nil {
-			log.Warning("MQTT connection lost")
+		if !client.closing {
+			log.Warningf("MQTT connection lost: %v", err)
 		} else {
 			log.Debug("MQTT connection closed")
 		}
 
-		client.connected = false
+		// Failed connection attempts are no state change
+		if client.connected {
+			callbacks = client.updateConnectionState(event)
+		}
 		client.connectedCond.Broadcast()
 	})
+	for _, callback := range callbacks {
+		callback(event)
+	}
 }
 
-// onPubSub wakes up a waiting doSubscribe/PublishRaw when a subscription/robust publish is finished.
-//
-//export onPubSub
-func onPubSub(mosq *C.struct_mosquitto, mid C.int) {
-	client := getClient(mosq)
-
+// onPubSub wakes up a waiting Subscribe/Unsubscribe/PublishRaw when a subscription/robust publish is finished.
+func (client *client) onPubSub(mid int) {
 	locked(client.currentMsgLock, func() {
 		if ch, ok := client.confirmWaiters[mid]; ok {
 			close(ch)
@@ -261,170 +447,581 @@ func onPubSub(mosq *C.struct_mosquitto, mid C.int) {
 	})
 }
 
-// getCallback returns the list of callbacks matching a given topic
-func (client *client) getCallbacks(messageTopic *C.char) []Callback {
-	callbacks := make([]Callback, 0)
+/* getCallbacks returns the list of dispatchers of the subscriptions matching a given topic.
+ * If the broker sent the subscription identifiers the message was delivered for, only
+ * the subscriptions of topics with one of these identifiers match, so that a message
+ * delivered once for a shared and once for a non-shared subscription runs every callback
+ * once.
+ */
+func (client *client) getCallbacks(messageTopic string, subscriptionIDs []int) []*dispatcher {
+	callbacks := make([]*dispatcher, 0)
 
 	locked(client.lock, func() {
-		for sub := range client.subscriptions {
-			subTopic := C.CString(sub.topic)
-			defer C.free(unsafe.Pointer(subTopic))
-
-			var matches C.bool
-			C.mosquitto_topic_matches_sub(subTopic, messageTopic, &matches)
-			if matches {
-				callbacks = append(callbacks, sub.callback)
+		client.subscriptionIndex.match(messageTopic, func(sub *subscription) {
+			if len(subscriptionIDs) > 0 {
+				topicSub, ok := client.subscribedTopics[sub.topic]
+				if ok && !slices.Contains(subscriptionIDs, topicSub.id) {
+					return
+				}
 			}
-		}
+			callbacks = append(callbacks, sub.dispatcher)
+		})
 	})
 
 	return callbacks
 }
 
-/* onMessage handles incoming messages and runs the corresponding callbacks.
- * The callbacks are run synchronously in the mosquitto thread, so they must
- * not block; all more complex processing should be run in Goroutines.
- * Still, the callbacks run concurrently with the Go main thread, so accesses
- * to common data structures always need to be synchronized.
+/* newSubscriptionID returns the MQTT v5 subscription identifier of a new broker
+ * subscription of topic, or 0 without MQTT v5. Brokers deliver messages for shared
+ * subscriptions separately, so every shared subscription gets an identifier of its own;
+ * all other subscriptions share nonSharedSubscriptionID. Must be called with client.lock
+ * held.
  */
-//export onMessage
-func onMessage(mosq *C.struct_mosquitto, message *C.struct_mosquitto_message) {
-	client := getClient(mosq)
-	callbacks := client.getCallbacks(message.topic)
-
-	topic := C.GoString(message.topic)
-	payload := C.GoBytes(message.payload, message.payloadlen)
+func (client *client) newSubscriptionID(topic string) int {
+	if !client.protocolV5 {
+		return 0
+	}
+	if _, _, shared := splitSharedFilter(topic); !shared {
+		return nonSharedSubscriptionID
+	}
+	client.lastSubscriptionID++
+	if client.lastSubscriptionID <= nonSharedSubscriptionID || client.lastSubscriptionID > maxSubscriptionID {
+		client.lastSubscriptionID = nonSharedSubscriptionID + 1
+	}
+	return client.lastSubscriptionID
+}
 
-	for _, cb := range callbacks {
-		cb(topic, payload)
+/* onMessage handles incoming messages and runs the corresponding callbacks.
+ * Unless WithAsyncDispatch is used, the callbacks are run synchronously in the
+ * network thread of the backend, so they must not block; all more complex processing should
+ * be run in Goroutines. Still, the callbacks run concurrently with the Go main
+ * thread, so accesses to common data structures always need to be synchronized.
+ * Panics of callbacks are recovered and logged by recoverCallback.
+ */
+func (client *client) onMessage(msg *Message) {
+	if client.metrics != nil {
+		client.metrics.MessageReceived(msg.Topic, len(msg.Payload))
+	}
+	for _, d := range client.getCallbacks(msg.Topic, msg.subscriptionIDs) {
+		d.deliver(msg)
 	}
 }
 
 func (client *client) Close() {
-	mosq := client.mosq
-
-	if mosq == nil {
-		return
-	}
+	alreadyClosing := false
 
 	locked(client.lock, func() {
-		client.mosq = nil
+		alreadyClosing = client.closing
+		if alreadyClosing {
+			return
+		}
+		client.closing = true
+		// Wakes up the goroutine flushing the offline queue
+		client.connectedCond.Broadcast()
 
-		C.mosquitto_disconnect(mosq)
+		client.backend.disconnect()
 
 		for client.connected {
 			client.connectedCond.Wait()
 		}
 	})
 
-	C.mosquitto_loop_stop(mosq, C.bool(true))
-	C.mosquitto_destroy(mosq)
+	if alreadyClosing {
+		return
+	}
+
+	if client.stopFlusher != nil {
+		client.stopFlusher()
+	}
+	client.flusher.Wait()
 
-	locked(&lock, func() {
-		delete(clients, mosq)
+	locked(client.currentMsgLock, func() {
+		client.backendStopped = true
+		for mid, ch := range client.confirmWaiters {
+			notifyWaiter(ch, ErrClientClosed)
+			delete(client.confirmWaiters, mid)
+		}
+	})
+	client.backend.stop()
+
+	locked(client.currentMsgLock, func() {
+		locked(client.lock, func() {
+			for sub := range client.subscriptions {
+				sub.stop()
+			}
+			clear(client.subscriptions)
+			clear(client.subscribedTopics)
+			client.subscriptionIndex = newTopicTrie[*subscription]()
+			client.queue.close()
+		})
 	})
 }
 
-/* doSubscribe is the low-level subscription function. It directly calls
- * mosquitto_subscribe (while holding doSubLock), optionally waiting for
- * the subscription request to finish.
+/* sendSubscribe is the low-level subscription function. It directly calls the
+ * backend and, if wait is true, returns the channel to wait for the confirmation
+ * of the returned message ID with waitForConfirm. While disconnected, nothing is
+ * sent and no channel is returned, as onConnect subscribes to all topics. Must be
+ * called with currentMsgLock held.
  *
- * If wait is true, but the subscription was not confirmed within
- * brokerConfirmTimeout, ErrConfirmTimedOut will be returned.
+ * Note: sendSubscribe may run either from Go (when called through Subscribe) or
+ * from the network thread of the backend (when called from onConnect to restore
+ * subscriptions); wait must be false then.
+ */
+func (client *client) sendSubscribe(topics []string, opts SubscribeOptions, id int, wait bool) (int, chan error, error) {
+	if client.backendStopped {
+		return 0, nil, ErrClientClosed
+	}
+	if !client.sessionUp {
+		// onConnect subscribes to the topics once connected
+		return 0, nil, nil
+	}
+	mid, err := client.backend.subscribe(topics, opts, id)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Subscription of topic '%s' failed: %w", strings.Join(topics, "', '"), err)
+	}
+	if !wait {
+		return mid, nil, nil
+	}
+	return mid, client.initConfirmWaiter(mid), nil
+}
+
+/* sendUnsubscribe removes the broker subscriptions of topics like sendSubscribe makes
+ * them. While disconnected, nothing is sent and no channel is returned; without clean
+ * session, onConnect unsubscribes from the topics once connected.
+ */
+func (client *client) sendUnsubscribe(topics []string, wait bool) (int, chan error, error) {
+	if client.backendStopped {
+		return 0, nil, ErrClientClosed
+	}
+	if !client.sessionUp {
+		if !client.cleanSession {
+			for _, topic := range topics {
+				client.pendingUnsubscribes[topic] = true
+			}
+		}
+		return 0, nil, nil
+	}
+	mid, err := client.backend.unsubscribe(topics)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Unsubscription of topic '%s' failed: %w", strings.Join(topics, "', '"), err)
+	}
+	if !wait {
+		return mid, nil, nil
+	}
+	return mid, client.initConfirmWaiter(mid), nil
+}
+
+/* Subscribe adds a subscription for a topic (or topic pattern), running the given callback
+ * for each message matching the topic. Subscriptions may be added and removed from different
+ * goroutines at the same time, also for the same topic.
  *
- * Note: doSubscribe is the only function of this package that may run either
- * from Go (when called through Subscribe) or from the libmosquitto handler
- * thread (when called from onConnect to restore subscriptions).
+ * The subscription options are only applied if there is no other subscription for the same
+ * topic yet, except for WithQoS: the broker subscription uses the highest QoS requested for
+ * the topic. Most other options require WithProtocolV5.
+ *
+ * While the client is disconnected, Subscribe returns without waiting and the broker
+ * subscription is made once connected again.
+ *
+ * Shared subscriptions (see SharedFilter) run the callback for messages matching the
+ * filter without the "$share/<group>/" prefix. A message matching both a shared and a
+ * non-shared subscription of the same client is delivered separately for each of them.
+ * With WithProtocolV5, subscription identifiers tell the deliveries apart, so each runs
+ * only the callbacks of its subscription; with MQTT 3.1.1 (or brokers not supporting
+ * subscription identifiers), each delivery runs the callbacks of both subscriptions.
  */
-func (client *client) doSubscribe(topic string, wait bool) error {
-	cTopic := C.CString(topic)
-	defer C.free(unsafe.Pointer(cTopic))
+func (client *client) Subscribe(topic string, callback Callback, opts ...SubscribeOption) (Subscription, error) {
+	return client.SubscribeContext(context.Background(), topic, callback, opts...)
+}
+
+// SubscribeContext works like Subscribe, but gives up waiting for the broker to
+// confirm the subscription when ctx is done. Without a deadline on ctx,
+// brokerConfirmTimeout applies.
+func (client *client) SubscribeContext(ctx context.Context, topic string, callback Callback,
+	opts ...SubscribeOption) (Subscription, error) {
+	if callback == nil {
+		return nil, errors.New("error during Subscription: empty topic or nil callback not allowed")
+	}
+	return client.SubscribeMessage(ctx, topic, func(message *Message) {
+		callback(message.Topic, message.Payload)
+	}, opts...)
+}
+
+// SubscribeMessage works like SubscribeContext, but passes the received messages
+// including their delivery metadata and MQTT v5 properties to callback.
+func (client *client) SubscribeMessage(ctx context.Context, topic string, callback MessageCallback,
+	opts ...SubscribeOption) (Subscription, error) {
+	if callback == nil {
+		return nil, errors.New("error during Subscription: empty topic or nil callback not allowed")
+	}
+	subOpts, err := NewSubscribeOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if subOpts.isV5() && !client.protocolV5 {
+		return nil, ErrProtocolV5Required
+	}
+	subs, err := client.subscribe(ctx, []subscriptionRequest{{topic: topic, callback: callback, opts: subOpts}})
+	if err != nil {
+		return nil, err
+	}
+	return subs[0], nil
+}
+
+// A subscriptionRequest is a validated subscription to be made by subscribe
+type subscriptionRequest struct {
+	topic    string
+	callback MessageCallback
+	opts     SubscribeOptions
+	// resubscribe sends a SUBSCRIBE even if the topic is subscribed already, so the
+	// broker sends its retained messages again. The QoS of an existing broker
+	// subscription is kept. As the SUBSCRIBE cannot be deferred, subscribing fails with
+	// errNotConnected while disconnected.
+	resubscribe bool
+}
+
+// A pendingSubscription is a subscription added by subscribe that is not confirmed yet
+type pendingSubscription struct {
+	sub *subscription
+	// needSub tells whether the broker subscription has to be made with opts
+	needSub     bool
+	resubscribe bool
+	opts        SubscribeOptions
+	// QoS of the broker subscription before, restored if subscribing fails
+	prevQoS byte
+}
+
+/* subscribe adds the requested subscriptions. The broker subscriptions required for
+ * them are made with a single request per set of options. If one of them fails, all
+ * subscriptions are removed again.
+ *
+ * Subscriptions of topics for which another call is still waiting for the broker
+ * subscription wait for it as well, and fail if it fails. Every call waits until its
+ * own ctx is done only; the request is given up once no call waits for it anymore.
+ */
+func (client *client) subscribe(ctx context.Context, requests []subscriptionRequest) ([]Subscription, error) {
+	for _, request := range requests {
+		if request.topic == "" {
+			return nil, errors.New("error during Subscription: empty topic or nil callback not allowed")
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := make([]*pendingSubscription, len(requests))
+	for i, request := range requests {
+		pending[i] = &pendingSubscription{
+			sub: &subscription{
+				dispatcher: newDispatcher(client.wrapCallback(request.callback), client.dispatch),
+				client:     client,
+				topic:      request.topic,
+			},
+			needSub:     true,
+			resubscribe: request.resubscribe,
+			opts:        request.opts,
+		}
+	}
 
 	var err error
-	var publishDone chan error
+	closed := false
+	notConnected := false
+	var groups []*topicGroup
+	// Broker subscriptions of other calls this call depends on
+	var others []*subscribeResult
+
 	locked(client.currentMsgLock, func() {
-		var currentSub C.int
-		ret := C.mosquitto_subscribe(client.mosq, &currentSub, cTopic, 2)
-		if ret != 0 {
-			err = errors.New("Subscription of topic '" + topic + "' failed")
+		if client.backendStopped {
+			closed = true
 			return
 		}
-		if wait {
-			// Only automatic resubscriptions set wait to false, and we do not want
-			// to disturb explicit subscriptions running at the same time.
-			publishDone = client.initConfirmWaiter(currentSub)
+		for _, p := range pending {
+			if p.resubscribe && !client.sessionUp {
+				notConnected = true
+				return
+			}
+		}
+
+		locked(client.lock, func() {
+			for _, p := range pending {
+				client.addSubscription(p.sub)
+				topicSub, ok := client.subscribedTopics[p.sub.topic]
+				if !ok {
+					topicSub = &topicSubscription{options: p.opts, id: client.newSubscriptionID(p.sub.topic)}
+					client.subscribedTopics[p.sub.topic] = topicSub
+				}
+				topicSub.refs++
+				p.prevQoS = topicSub.options.QoS
+
+				if topicSub.refs > 1 {
+					if p.resubscribe {
+						p.opts.QoS = topicSub.options.QoS
+					}
+					// Subscribing again replaces the QoS of the existing broker subscription
+					p.needSub = p.opts.QoS > topicSub.options.QoS || p.resubscribe
+					if p.opts.QoS > topicSub.options.QoS {
+						topicSub.options.QoS = p.opts.QoS
+					}
+				}
+				p.opts = topicSub.options
+
+				if p.needSub {
+					groups = groupTopic(groups, p.sub.topic, p.opts, topicSub.id)
+				} else if topicSub.pending != nil {
+					topicSub.pending.waiters++
+					others = append(others, topicSub.pending)
+				}
+			}
+
+			for _, group := range groups {
+				group.result = &subscribeResult{topics: group.topics, waiters: 1, done: make(chan struct{})}
+				for _, topic := range group.topics {
+					client.subscribedTopics[topic].pending = group.result
+				}
+			}
+		})
+
+		for _, group := range groups {
+			result := group.result
+			if err == nil {
+				result.mid, result.confirmed, err = client.sendSubscribe(group.topics, group.opts, group.id, true)
+			}
+			if err != nil || result.confirmed == nil {
+				// Not sent, or made by onConnect once connected
+				client.finishSubscribe(result, err)
+			}
 		}
 	})
-	if err == nil && publishDone != nil {
-		err = <-publishDone
+	if closed || notConnected {
+		for _, p := range pending {
+			p.sub.stop()
+		}
+		if notConnected {
+			return nil, errNotConnected
+		}
+		return nil, ErrClientClosed
 	}
 
-	return err
+	results := make([]*subscribeResult, 0, len(groups)+len(others))
+	for _, group := range groups {
+		results = append(results, group.result)
+	}
+	results = append(results, others...)
+	waited := 0
+	for ; waited < len(results) && err == nil; waited++ {
+		err = client.waitForSubscribe(ctx, results[waited])
+	}
+	if waited < len(results) {
+		// Requests after a failed one are not waited for anymore
+		locked(client.currentMsgLock, func() {
+			for _, result := range results[waited:] {
+				client.leaveSubscribe(result, err)
+			}
+		})
+	}
+
+	if err != nil {
+		client.dropPending(pending)
+		return nil, err
+	}
+
+	subs := make([]Subscription, len(pending))
+	for i, p := range pending {
+		subs[i] = p.sub
+	}
+	return subs, nil
 }
 
-/* Subscribe adds a subscription for a topic (or topic pattern), running the given callback
- * for each message matching the topic. The locking found in the functions only synchoronizes
- * the thread/gorouting running Subscribe against the libmosquitto handler thread. The user of
- * the mqtt-handler must ensure that there are no concurrent calls to Subscribe/Unsubscribe.
- */
-func (client *client) Subscribe(topic string, callback Callback) (Subscription, error) {
-	if topic == "" || callback == nil {
-		return nil, errors.New("error during Subscription: empty topic or nil callback not allowed")
+// waitForSubscribe blocks until the request of result is finished or ctx is done, like
+// waitForConfirm. The request is given up if ctx is done and no other call waits for it.
+func (client *client) waitForSubscribe(ctx context.Context, result *subscribeResult) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, brokerConfirmTimeout, ErrConfirmTimedOut)
+		defer cancel()
 	}
-	sub := &subscription{
-		client:   client,
-		topic:    topic,
-		callback: callback,
+
+	select {
+	case err := <-result.confirmed:
+		// Every waiting call receives the closed channel, but only one the error sent by Close
+		locked(client.currentMsgLock, func() {
+			client.finishSubscribe(result, err)
+		})
+		return result.err
+	case <-result.done:
+		return result.err
+	case <-ctx.Done():
 	}
 
-	needSub := true
+	err := context.Cause(ctx)
+	finished := false
+	locked(client.currentMsgLock, func() {
+		// The request may have finished while ctx was done
+		select {
+		case confirmErr := <-result.confirmed:
+			client.finishSubscribe(result, confirmErr)
+		default:
+		}
+		select {
+		case <-result.done:
+			finished = true
+		default:
+			client.leaveSubscribe(result, err)
+		}
+	})
+	if finished {
+		return result.err
+	}
+	if errors.Is(err, ErrConfirmTimedOut) && client.metrics != nil {
+		client.metrics.ConfirmTimedOut()
+	}
+	return err
+}
 
-	locked(client.lock, func() {
-		client.subscriptions[sub] = true
-		client.subscribedTopics[topic]++
+// leaveSubscribe stops waiting for result and gives it up with err if no other call waits
+// for it. Must be called with currentMsgLock held.
+func (client *client) leaveSubscribe(result *subscribeResult, err error) {
+	result.waiters--
+	if result.waiters == 0 {
+		client.finishSubscribe(result, err)
+	}
+}
+
+// finishSubscribe sets the outcome of result unless it is finished already. Must be called
+// with currentMsgLock held.
+func (client *client) finishSubscribe(result *subscribeResult, err error) {
+	select {
+	case <-result.done:
+		return
+	default:
+	}
+	if ch, ok := client.confirmWaiters[result.mid]; ok && ch == result.confirmed {
+		delete(client.confirmWaiters, result.mid)
+	}
+	result.err = err
+	close(result.done)
 
-		if client.subscribedTopics[topic] > 1 {
-			needSub = false
+	locked(client.lock, func() {
+		for _, topic := range result.topics {
+			if topicSub, ok := client.subscribedTopics[topic]; ok && topicSub.pending == result {
+				topicSub.pending = nil
+			}
 		}
 	})
+}
 
-	if needSub {
-		err := client.doSubscribe(topic, true)
-		if err != nil {
-			locked(client.lock, func() {
-				delete(client.subscriptions, sub)
-			})
-			return nil, err
+// dropPending removes the subscriptions of a failed subscribe call and unsubscribes
+// the topics no longer needed, as the broker may have subscribed some of them
+func (client *client) dropPending(pending []*pendingSubscription) {
+	for _, p := range pending {
+		p.sub.stop()
+	}
+
+	locked(client.currentMsgLock, func() {
+		topics := make([]string, 0)
+		locked(client.lock, func() {
+			// In reverse order, so the QoS of each topic is restored to the value before the call
+			for i := len(pending) - 1; i >= 0; i-- {
+				p := pending[i]
+				if !client.removeSubscription(p.sub) {
+					// Removed by Close
+					continue
+				}
+				if topicSub := client.subscribedTopics[p.sub.topic]; p.needSub && topicSub.options.QoS == p.opts.QoS {
+					topicSub.options.QoS = p.prevQoS
+				}
+				if client.releaseTopic(p.sub.topic) {
+					topics = append(topics, p.sub.topic)
+				}
+			}
+		})
+
+		if len(topics) > 0 {
+			_, _, _ = client.sendUnsubscribe(topics, false)
 		}
+	})
+}
+
+// addSubscription registers sub for incoming messages. Must be called with client.lock held.
+func (client *client) addSubscription(sub *subscription) {
+	client.subscriptions[sub] = true
+	client.subscriptionIndex.add(matchFilter(sub.topic), sub)
+}
+
+// removeSubscription unregisters sub and returns whether it was registered.
+// Must be called with client.lock held.
+func (client *client) removeSubscription(sub *subscription) bool {
+	if !client.subscriptions[sub] {
+		return false
 	}
+	delete(client.subscriptions, sub)
+	client.subscriptionIndex.remove(matchFilter(sub.topic), sub)
+	return true
+}
 
-	return sub, nil
+// releaseTopic drops a reference to the broker subscription of topic and returns
+// whether it was the last one. Must be called with client.lock held.
+func (client *client) releaseTopic(topic string) bool {
+	topicSub, ok := client.subscribedTopics[topic]
+	if !ok {
+		return false
+	}
+	topicSub.refs--
+	if topicSub.refs > 0 {
+		return false
+	}
+	delete(client.subscribedTopics, topic)
+	return true
 }
 
+// Unsubscribe removes the subscription without waiting for the broker to confirm it.
+// Errors are ignored, so it may be called again or after closing the client.
 func (sub *subscription) Unsubscribe() {
+	_ = sub.unsubscribe(context.Background(), false)
+}
+
+// UnsubscribeContext removes the subscription and waits for the broker to confirm it until
+// ctx is done; without a deadline on ctx, brokerConfirmTimeout applies. The subscription is
+// removed even if an error is returned. It fails with ErrNotSubscribed if the subscription was
+// removed before and with ErrClientClosed if the client is closed. While the client is
+// disconnected, it returns without waiting, like Subscribe.
+func (sub *subscription) UnsubscribeContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return sub.unsubscribe(ctx, true)
+}
+
+func (sub *subscription) unsubscribe(ctx context.Context, wait bool) error {
 	client := sub.client
 
-	needUnsub := false
+	var err error
+	var mid int
+	var confirmed chan error
 
-	locked(client.lock, func() {
-		if client.subscriptions[sub] {
-			delete(client.subscriptions, sub)
-			client.subscribedTopics[sub.topic]--
-			if client.subscribedTopics[sub.topic] == 0 {
-				delete(client.subscribedTopics, sub.topic)
-				needUnsub = true
+	sub.stop()
+
+	locked(client.currentMsgLock, func() {
+		needUnsub := false
+		locked(client.lock, func() {
+			switch {
+			case client.closing:
+				err = ErrClientClosed
+			case !client.removeSubscription(sub):
+				err = ErrNotSubscribed
+			default:
+				needUnsub = client.releaseTopic(sub.topic)
 			}
+		})
+
+		if needUnsub {
+			mid, confirmed, err = client.sendUnsubscribe([]string{sub.topic}, wait)
 		}
 	})
 
-	if needUnsub {
-		cTopic := C.CString(sub.topic)
-		defer C.free(unsafe.Pointer(cTopic))
-
-		locked(client.currentMsgLock, func() {
-			C.mosquitto_unsubscribe(client.mosq, nil, cTopic)
-		})
+	if err != nil || confirmed == nil {
+		return err
 	}
+	return client.waitForConfirm(ctx, mid, confirmed)
 }
 
 // PublishRaw publishes a message to the MQTT broker.
@@ -432,32 +1029,108 @@ func (sub *subscription) Unsubscribe() {
 // If qos is greater than 0, but the publication was not confirmed
 // within brokerConfirmTimeout, ErrConfirmTimedOut will be returned.
 func (client *client) PublishRaw(topic string, qos byte, retain bool, message []byte) error {
-	cTopic := C.CString(topic)
-	defer C.free(unsafe.Pointer(cTopic))
+	return client.PublishRawContext(context.Background(), topic, qos, retain, message)
+}
+
+// PublishRawContext works like PublishRaw, but gives up waiting for the broker
+// to confirm a publication with qos greater than 0 when ctx is done. Without a
+// deadline on ctx, brokerConfirmTimeout applies.
+func (client *client) PublishRawContext(ctx context.Context, topic string, qos byte, retain bool, message []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return client.publish(ctx, topic, qos, retain, message, nil)
+}
+
+// PublishWithProperties works like PublishRawContext, but attaches MQTT v5
+// properties to the message, which requires WithProtocolV5.
+func (client *client) PublishWithProperties(ctx context.Context, topic string, qos byte, retain bool, message []byte,
+	properties *Properties) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if properties != nil {
+		if !client.protocolV5 {
+			return ErrProtocolV5Required
+		}
+		if err := properties.validate(); err != nil {
+			return err
+		}
+	}
+
+	return client.publish(ctx, topic, qos, retain, message, properties)
+}
+
+// publish publishes a message through the publish middlewares
+func (client *client) publish(ctx context.Context, topic string, qos byte, retain bool, message []byte,
+	properties *Properties) error {
+	return client.publishChain(ctx, &Message{Topic: topic, Payload: message, QoS: qos, Retain: retain, Properties: properties})
+}
+
+/* publishMessage publishes a message like doPublish, waiting for the broker to confirm
+ * it if its QoS is greater than 0. With the offline queue, the message is queued
+ * instead while the client is disconnected or older messages are still queued;
+ * it is also queued if publishing failed because the connection was lost.
+ */
+func (client *client) publishMessage(ctx context.Context, msg *Message) error {
+	topic, qos, retain, message, properties := msg.Topic, msg.QoS, msg.Retain, msg.Payload, msg.Properties
+	if properties != nil && !client.protocolV5 {
+		return ErrProtocolV5Required
+	}
+	if client.queue == nil {
+		return client.doPublish(ctx, topic, qos, retain, message, properties, qos > 0)
+	}
 
-	var ptr unsafe.Pointer
+	pub := &queuedPublication{Topic: topic, Payload: message, QoS: qos, Retain: retain, Properties: properties}
+	if queued, err := client.enqueue(pub); queued {
+		return err
+	}
 
-	msglen := len(message)
-	if msglen > 0 {
-		ptr = unsafe.Pointer(&message[0])
+	err := client.doPublish(ctx, topic, qos, retain, message, properties, qos > 0)
+	if err != nil && ctx.Err() == nil {
+		if queued, queueErr := client.enqueue(pub); queued {
+			return queueErr
+		}
 	}
+	return err
+}
 
+/* doPublish is the low-level publish function. It directly calls the backend
+ * (while holding currentMsgLock), optionally waiting for the broker to confirm the
+ * publication, which requires qos to be greater than 0.
+ *
+ * Like sendSubscribe, doPublish may run from the network thread of the backend (when
+ * called from onConnect to publish the birth message); wait must be false then.
+ */
+func (client *client) doPublish(ctx context.Context, topic string, qos byte, retain bool, message []byte,
+	properties *Properties, wait bool) error {
 	var err error
+	var currentMsg int
 	var publishDone chan error
+	start := time.Now()
 	locked(client.currentMsgLock, func() {
-		var currentMsg C.int
-		ret := C.mosquitto_publish(client.mosq, &currentMsg, cTopic, C.int(msglen),
-			ptr, C.int(qos), C.bool(retain))
-		if ret != 0 {
-			err = errors.New("failed to publish message")
+		if client.backendStopped {
+			err = ErrClientClosed
+			return
+		}
+		currentMsg, err = client.backend.publish(topic, qos, retain, message, properties)
+		if err != nil {
+			err = fmt.Errorf("failed to publish message: %w", err)
 			return
 		}
-		if qos > 0 {
+		if wait {
 			publishDone = client.initConfirmWaiter(currentMsg)
 		}
 	})
+	if err == nil && client.metrics != nil {
+		client.metrics.MessagePublished(topic, len(message))
+	}
 	if err == nil && publishDone != nil {
-		err = <-publishDone
+		err = client.waitForConfirm(ctx, currentMsg, publishDone)
+		if err == nil && client.metrics != nil {
+			client.metrics.PublishConfirmed(time.Since(start))
+		}
 	}
 
 	return err
@@ -468,40 +1141,81 @@ func (client *client) PublishEmpty(topic string, qos byte, retain bool) error {
 }
 
 func (client *client) Publish(topic string, qos byte, retain bool, message proto.Message) error {
-	type vtProtoMessage interface{ MarshalVT() ([]byte, error) }
-	var marshalledProto []byte
-	var err error
-	if vtmessage, ok := message.(vtProtoMessage); ok {
-		// Use the faster MarshalVT if available.
-		marshalledProto, err = vtmessage.MarshalVT()
-	} else {
-		marshalledProto, err = proto.Marshal(message)
-	}
+	return client.PublishContext(context.Background(), topic, qos, retain, message)
+}
 
+// PublishContext marshals message and publishes it like PublishRawContext.
+func (client *client) PublishContext(ctx context.Context, topic string, qos byte, retain bool, message proto.Message) error {
+	marshalledProto, err := marshalProto(message)
 	if err != nil {
 		log.Panic(err.Error())
 		return err
 	}
 
-	return client.PublishRaw(topic, qos, retain, marshalledProto)
+	return client.PublishRawContext(ctx, topic, qos, retain, marshalledProto)
 }
 
 // initConfirmWaiter adds a channel for mid to client.confirmWaiters
-// and returns this channel. If the channel is still present in
-// client.confirmWaiters after brokerConfirmTimeout, ErrConfirmTimedOut
-// will be sent to it and it will be closed and removed again from
-// client.confirmWaiters.
-func (client *client) initConfirmWaiter(mid C.int) chan error {
-	publishDone := make(chan error)
+// and returns this channel. The channel is closed by onPubSub as soon
+// as the broker confirms mid. Must be called with currentMsgLock held.
+func (client *client) initConfirmWaiter(mid int) chan error {
+	if previous, ok := client.confirmWaiters[mid]; ok {
+		// The backend reuses the message ID of a request lost with the connection
+		notifyWaiter(previous, errConfirmLost)
+	}
+	// Close sends ErrClientClosed without waiting for a receiver
+	publishDone := make(chan error, 1)
 	client.confirmWaiters[mid] = publishDone
-	time.AfterFunc(brokerConfirmTimeout, func() {
-		client.currentMsgLock.Lock()
-		defer client.currentMsgLock.Unlock()
-		if ch, ok := client.confirmWaiters[mid]; ok {
-			ch <- ErrConfirmTimedOut
-			close(ch)
+	return publishDone
+}
+
+// notifyWaiter sends err to a channel of confirmWaiters that is removed from it, unless
+// the channel holds an error already
+func notifyWaiter(publishDone chan error, err error) {
+	select {
+	case publishDone <- err:
+	default:
+	}
+}
+
+// waitForConfirm blocks until publishDone, as returned by initConfirmWaiter
+// for mid, is closed (or receives ErrClientClosed) or ctx is done. If ctx has no deadline, waiting is
+// limited to brokerConfirmTimeout and ErrConfirmTimedOut is returned when
+// it has passed. If waiting is aborted, the channel is removed from
+// client.confirmWaiters again.
+func (client *client) waitForConfirm(ctx context.Context, mid int, publishDone chan error) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, brokerConfirmTimeout, ErrConfirmTimedOut)
+		defer cancel()
+	}
+
+	select {
+	case err := <-publishDone:
+		return err
+	case <-ctx.Done():
+	}
+
+	confirmed := true
+	locked(client.currentMsgLock, func() {
+		if ch, ok := client.confirmWaiters[mid]; ok && ch == publishDone {
 			delete(client.confirmWaiters, mid)
+			confirmed = false
 		}
 	})
-	return publishDone
+	if confirmed {
+		// onPubSub closed the channel, or Close or initConfirmWaiter sent an error while
+		// ctx was done
+		select {
+		case err := <-publishDone:
+			return err
+		default:
+		}
+	}
+
+	err := context.Cause(ctx)
+	if errors.Is(err, ErrConfirmTimedOut) && client.metrics != nil {
+		client.metrics.ConfirmTimedOut()
+	}
+	return err
 }
@@ -15,6 +15,7 @@
 package mqtt
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -163,6 +164,26 @@ func TestBroker(t *testing.T) {
 		assert.NoError(t, err)
 	})
 
+	t.Run("Subscribe and publish with cancelled context", func(t *testing.T) {
+		clientSub, err := NewClient(MQTTBrokerHost, MQTTBrokerPort, "MQTTSubscriber")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clientSub.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err = clientSub.SubscribeContext(ctx, topic, func(string, []byte) {})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		err = clientSub.PublishRawContext(ctx, topic, 1, false, []byte{})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		_, err = NewClientContext(ctx, MQTTBrokerHost, MQTTBrokerPort, "MQTTPublisher")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
 	t.Run("Publish to broker", func(t *testing.T) {
 		waitGroup := &sync.WaitGroup{}
 
@@ -191,6 +212,92 @@ func TestBroker(t *testing.T) {
 		assert.Nil(t, err)
 	})
 
+	t.Run("Birth message", func(t *testing.T) {
+		waitGroup := &sync.WaitGroup{}
+
+		clientSub, err := NewClient(MQTTBrokerHost, MQTTBrokerPort, "MQTTSubscriber")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clientSub.Close()
+
+		waitGroup.Add(1)
+		subscription, err := clientSub.Subscribe("status/MQTTPublisher", func(topic string, msg []byte) {
+			assert.Equal(t, "online", string(msg))
+			waitGroup.Done()
+		})
+		assert.Nil(t, err)
+		defer subscription.Unsubscribe()
+
+		clientPub, err := NewClientWithOptions(MQTTBrokerHost, MQTTBrokerPort, "MQTTPublisher",
+			WithWill("status/MQTTPublisher", []byte("offline"), 1, false),
+			WithBirthMessage("status/MQTTPublisher", []byte("online"), 1, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clientPub.Close()
+
+		// The test succeeds if we do not timeout here
+		err = waitWithTimeout(waitGroup, time.Duration(time.Second*2))
+		assert.Nil(t, err)
+	})
+
+	t.Run("Publish with MQTT v5 properties", func(t *testing.T) {
+		waitGroup := &sync.WaitGroup{}
+
+		clientSub, err := NewClientWithOptions(MQTTBrokerHost, MQTTBrokerPort, "MQTTSubscriber", WithProtocolV5())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clientSub.Close()
+
+		properties := &Properties{
+			ContentType:     "application/x-protobuf",
+			ResponseTopic:   "reply",
+			CorrelationData: []byte{1, 2, 3},
+			MessageExpiry:   time.Minute,
+			UserProperties:  []UserProperty{{Key: "unit", Value: "Wh"}},
+		}
+
+		waitGroup.Add(1)
+		subscription, err := clientSub.SubscribeMessage(context.Background(), topic, func(message *Message) {
+			defer waitGroup.Done()
+			assert.Equal(t, properties.ContentType, message.Properties.ContentType)
+			assert.Equal(t, properties.ResponseTopic, message.Properties.ResponseTopic)
+			assert.Equal(t, properties.CorrelationData, message.Properties.CorrelationData)
+			assert.Equal(t, properties.UserProperties, message.Properties.UserProperties)
+		}, WithNoLocal())
+		assert.Nil(t, err)
+		defer subscription.Unsubscribe()
+
+		clientPub, err := NewClientWithOptions(MQTTBrokerHost, MQTTBrokerPort, "MQTTPublisher", WithProtocolV5())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clientPub.Close()
+
+		err = clientPub.PublishWithProperties(context.Background(), topic, 1, false, []byte{}, properties)
+		assert.Nil(t, err)
+
+		// The test succeeds if we do not timeout here
+		err = waitWithTimeout(waitGroup, time.Duration(time.Second*2))
+		assert.Nil(t, err)
+	})
+
+	t.Run("MQTT v5 features on MQTT 3.1.1 connection", func(t *testing.T) {
+		client, err := NewClient(MQTTBrokerHost, MQTTBrokerPort, "MQTTPublisher")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		err = client.PublishWithProperties(context.Background(), topic, 0, false, []byte{}, &Properties{})
+		assert.ErrorIs(t, err, ErrProtocolV5Required)
+
+		_, err = client.SubscribeMessage(context.Background(), topic, func(*Message) {}, WithNoLocal())
+		assert.ErrorIs(t, err, ErrProtocolV5Required)
+	})
+
 	t.Run("Unsubscribe from broker", func(t *testing.T) {
 		waitGroup := &sync.WaitGroup{}
 
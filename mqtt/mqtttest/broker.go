@@ -0,0 +1,517 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+// Package mqtttest provides an in-memory MQTT broker for tests. Its clients
+// implement mqtt.Client, so code using MQTT can be tested end-to-end without
+// a running broker.
+package mqtttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/tq-systems/public-go-utils/v3/mqtt"
+)
+
+// ErrClosed is returned when a closed Client is used. It is mqtt.ErrClientClosed,
+// like for closed clients of the mqtt package.
+var ErrClosed = mqtt.ErrClientClosed
+
+var _ mqtt.Client = (*Client)(nil)
+
+/* A Broker routes messages between its clients. Messages are delivered
+ * synchronously: when Publish returns, the callbacks of all matching
+ * subscriptions have run. Callbacks may use the clients again, e.g. to
+ * publish a response.
+ *
+ * The broker behaves like an MQTT v5 broker: message properties and
+ * subscription options are supported on all clients. QoS is accepted, but
+ * has no effect as messages cannot get lost. Messages for shared subscriptions
+ * are delivered to the clients of a group in turn, ordered by client ID.
+ */
+type Broker struct {
+	lock     sync.Mutex
+	clients  map[*Client]bool
+	retained map[string]*mqtt.Message
+	// shared counts the messages delivered per shared subscription filter
+	shared map[string]int
+}
+
+// A Client is a connection to a Broker implementing mqtt.Client
+type Client struct {
+	broker   *Broker
+	clientID string
+
+	lock          sync.Mutex
+	subscriptions map[*subscription]bool
+	closed        bool
+	state         mqtt.ConnectionState
+	watchers      map[*watcher]bool
+}
+
+type watcher struct {
+	callback mqtt.ConnectionCallback
+}
+
+type subscription struct {
+	client   *Client
+	filter   string
+	callback mqtt.MessageCallback
+	noLocal  bool
+	qos      byte
+	// retainAsPublished keeps the retain flag of live messages
+	retainAsPublished bool
+}
+
+// deliver runs the callback of sub with a copy of message as the broker would send it
+func (sub *subscription) deliver(message *mqtt.Message, retain bool) {
+	delivered := *message
+	delivered.QoS = min(message.QoS, sub.qos)
+	delivered.Retain = retain
+	sub.callback(&delivered)
+}
+
+// NewBroker creates a new, empty broker
+func NewBroker() *Broker {
+	return &Broker{
+		clients:  make(map[*Client]bool),
+		retained: make(map[string]*mqtt.Message),
+		shared:   make(map[string]int),
+	}
+}
+
+// NewClient connects a new client to the broker
+func (broker *Broker) NewClient(clientID string) *Client {
+	client := &Client{
+		broker:        broker,
+		clientID:      clientID,
+		subscriptions: make(map[*subscription]bool),
+		state:         mqtt.ConnectionState{Connected: true, LastConnect: time.Now()},
+		watchers:      make(map[*watcher]bool),
+	}
+
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	broker.clients[client] = true
+
+	return client
+}
+
+// Retained returns the retained message of topic, if there is one
+func (broker *Broker) Retained(topic string) (*mqtt.Message, bool) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	message, ok := broker.retained[topic]
+	return message, ok
+}
+
+// publish stores a retained message and delivers the message to all matching subscriptions
+func (broker *Broker) publish(sender *Client, retain bool, message *mqtt.Message) {
+	var subscriptions []*subscription
+
+	broker.lock.Lock()
+	if retain {
+		if len(message.Payload) == 0 {
+			delete(broker.retained, message.Topic)
+		} else {
+			retained := *message
+			retained.Retain = true
+			broker.retained[message.Topic] = &retained
+		}
+	}
+	shared := make(map[string][]*subscription)
+	for client := range broker.clients {
+		for _, sub := range client.matchingSubscriptions(message.Topic) {
+			if isShared(sub.filter) {
+				shared[sub.filter] = append(shared[sub.filter], sub)
+			} else {
+				subscriptions = append(subscriptions, sub)
+			}
+		}
+	}
+	for filter, subs := range shared {
+		subscriptions = append(subscriptions, broker.nextSharedClient(filter, subs)...)
+	}
+	broker.lock.Unlock()
+
+	for _, sub := range subscriptions {
+		if sub.noLocal && sub.client == sender {
+			continue
+		}
+		sub.deliver(message, retain && sub.retainAsPublished)
+	}
+}
+
+// nextSharedClient returns the subscriptions of the client of a shared subscription
+// group that receives the next message for filter. Must be called with broker.lock held.
+func (broker *Broker) nextSharedClient(filter string, subs []*subscription) []*subscription {
+	clients := make([]*Client, 0, len(subs))
+	for _, sub := range subs {
+		if !slices.Contains(clients, sub.client) {
+			clients = append(clients, sub.client)
+		}
+	}
+	slices.SortFunc(clients, func(a, b *Client) int {
+		return strings.Compare(a.clientID, b.clientID)
+	})
+	client := clients[broker.shared[filter]%len(clients)]
+	broker.shared[filter]++
+
+	return slices.DeleteFunc(subs, func(sub *subscription) bool {
+		return sub.client != client
+	})
+}
+
+// isShared returns whether filter is a shared subscription filter
+func isShared(filter string) bool {
+	return strings.HasPrefix(filter, "$share/")
+}
+
+// retainedMessages returns the retained messages matching filter
+func (broker *Broker) retainedMessages(filter string) []*mqtt.Message {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	messages := make([]*mqtt.Message, 0)
+	for topic, message := range broker.retained {
+		if mqtt.TopicMatches(filter, topic) {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+func (client *Client) matchingSubscriptions(topic string) []*subscription {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	subscriptions := make([]*subscription, 0)
+	for sub := range client.subscriptions {
+		if mqtt.TopicMatches(sub.filter, topic) {
+			subscriptions = append(subscriptions, sub)
+		}
+	}
+	return subscriptions
+}
+
+// hasFilter returns whether the client has a subscription for filter
+func (client *Client) hasFilter(filter string) bool {
+	for sub := range client.subscriptions {
+		if sub.filter == filter {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe implements mqtt.Client
+func (client *Client) Subscribe(topic string, callback mqtt.Callback, opts ...mqtt.SubscribeOption) (mqtt.Subscription, error) {
+	return client.SubscribeContext(context.Background(), topic, callback, opts...)
+}
+
+// SubscribeContext implements mqtt.Client
+func (client *Client) SubscribeContext(ctx context.Context, topic string, callback mqtt.Callback,
+	opts ...mqtt.SubscribeOption) (mqtt.Subscription, error) {
+	if callback == nil {
+		return nil, errors.New("error during Subscription: empty topic or nil callback not allowed")
+	}
+	return client.SubscribeMessage(ctx, topic, func(message *mqtt.Message) {
+		callback(message.Topic, message.Payload)
+	}, opts...)
+}
+
+// SubscribeMessage implements mqtt.Client
+func (client *Client) SubscribeMessage(ctx context.Context, topic string, callback mqtt.MessageCallback,
+	opts ...mqtt.SubscribeOption) (mqtt.Subscription, error) {
+	if topic == "" || callback == nil {
+		return nil, errors.New("error during Subscription: empty topic or nil callback not allowed")
+	}
+	if !mqtt.ValidTopicFilter(topic) {
+		return nil, fmt.Errorf("Subscription of topic '%s' failed", topic)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	subOpts, err := mqtt.NewSubscribeOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	sub := &subscription{
+		client:   client,
+		filter:   topic,
+		callback: callback,
+		noLocal:  subOpts.NoLocal,
+		qos:      subOpts.QoS,
+
+		retainAsPublished: subOpts.RetainAsPublished,
+	}
+
+	client.lock.Lock()
+	if client.closed {
+		client.lock.Unlock()
+		return nil, ErrClosed
+	}
+	existed := client.hasFilter(topic)
+	client.subscriptions[sub] = true
+	client.lock.Unlock()
+
+	// like in MQTT brokers, shared subscriptions receive no retained messages
+	sendRetained := !isShared(topic) && (subOpts.RetainHandling == mqtt.SendRetainAlways ||
+		(subOpts.RetainHandling == mqtt.SendRetainNew && !existed))
+	if sendRetained {
+		for _, message := range client.broker.retainedMessages(topic) {
+			sub.deliver(message, true)
+		}
+	}
+
+	return sub, nil
+}
+
+// SubscribeMany implements mqtt.Client. If one of the subscriptions fails, the ones
+// made before are removed again.
+func (client *Client) SubscribeMany(ctx context.Context, requests []mqtt.SubscriptionRequest) ([]mqtt.Subscription, error) {
+	subs := make([]mqtt.Subscription, 0, len(requests))
+	for _, request := range requests {
+		sub, err := client.SubscribeMessage(ctx, request.Topic, request.Callback, request.Options...)
+		if err != nil {
+			_ = client.UnsubscribeMany(ctx, subs)
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// UnsubscribeMany implements mqtt.Client
+func (client *Client) UnsubscribeMany(_ context.Context, subscriptions []mqtt.Subscription) error {
+	client.lock.Lock()
+	closed := client.closed
+	client.lock.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	for _, sub := range subscriptions {
+		sub.Unsubscribe()
+	}
+	return nil
+}
+
+// Unsubscribe implements mqtt.Subscription
+func (sub *subscription) Unsubscribe() {
+	_ = sub.UnsubscribeContext(context.Background())
+}
+
+// UnsubscribeContext implements mqtt.Subscription
+func (sub *subscription) UnsubscribeContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sub.client.lock.Lock()
+	defer sub.client.lock.Unlock()
+	if sub.client.closed {
+		return ErrClosed
+	}
+	if !sub.client.subscriptions[sub] {
+		return mqtt.ErrNotSubscribed
+	}
+	delete(sub.client.subscriptions, sub)
+	return nil
+}
+
+// Dropped implements mqtt.Subscription. Messages are never dropped.
+func (sub *subscription) Dropped() uint64 {
+	return 0
+}
+
+// PublishRaw implements mqtt.Client
+func (client *Client) PublishRaw(topic string, qos byte, retain bool, message []byte) error {
+	return client.PublishRawContext(context.Background(), topic, qos, retain, message)
+}
+
+// PublishRawContext implements mqtt.Client
+func (client *Client) PublishRawContext(ctx context.Context, topic string, qos byte, retain bool, message []byte) error {
+	return client.PublishWithProperties(ctx, topic, qos, retain, message, nil)
+}
+
+// PublishWithProperties implements mqtt.Client
+func (client *Client) PublishWithProperties(ctx context.Context, topic string, qos byte, retain bool,
+	message []byte, properties *mqtt.Properties) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !mqtt.ValidTopicName(topic) || qos > 2 {
+		return errors.New("failed to publish message")
+	}
+
+	client.lock.Lock()
+	closed := client.closed
+	client.lock.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	client.broker.publish(client, retain, &mqtt.Message{
+		Topic:      topic,
+		Payload:    append([]byte{}, message...),
+		Properties: properties,
+		QoS:        qos,
+	})
+
+	return nil
+}
+
+// PublishEmpty implements mqtt.Client
+func (client *Client) PublishEmpty(topic string, qos byte, retain bool) error {
+	return client.PublishRaw(topic, qos, retain, []byte{})
+}
+
+// Publish implements mqtt.Client
+func (client *Client) Publish(topic string, qos byte, retain bool, message proto.Message) error {
+	return client.PublishContext(context.Background(), topic, qos, retain, message)
+}
+
+// PublishContext implements mqtt.Client
+func (client *Client) PublishContext(ctx context.Context, topic string, qos byte, retain bool, message proto.Message) error {
+	payload, err := proto.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return client.PublishRawContext(ctx, topic, qos, retain, payload)
+}
+
+// GetRetained implements mqtt.Client. The retained message is taken from the broker
+// without waiting.
+func (client *Client) GetRetained(ctx context.Context, topic string) (*mqtt.Message, error) {
+	if !mqtt.ValidTopicName(topic) {
+		return nil, errors.New("failed to get retained message: invalid topic name")
+	}
+	messages, err := client.GetRetainedPattern(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, mqtt.ErrNoRetained
+	}
+	return messages[0], nil
+}
+
+// GetRetainedPattern implements mqtt.Client. The retained messages are taken from
+// the broker without waiting.
+func (client *Client) GetRetainedPattern(ctx context.Context, filter string) ([]*mqtt.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !mqtt.ValidTopicFilter(filter) {
+		return nil, errors.New("failed to get retained messages: invalid topic filter")
+	}
+	if isShared(filter) {
+		return nil, errors.New("failed to get retained messages: shared subscription filter")
+	}
+
+	client.lock.Lock()
+	closed := client.closed
+	client.lock.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	messages := client.broker.retainedMessages(filter)
+	slices.SortFunc(messages, func(a, b *mqtt.Message) int {
+		return strings.Compare(a.Topic, b.Topic)
+	})
+	return messages, nil
+}
+
+// ClearRetained implements mqtt.Client
+func (client *Client) ClearRetained(ctx context.Context, filter string) error {
+	messages, err := client.GetRetainedPattern(ctx, filter)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		if err := client.PublishEmpty(message.Topic, 1, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsConnected implements mqtt.Client. A client is connected until it is closed.
+func (client *Client) IsConnected() bool {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return !client.closed
+}
+
+// ConnectionState implements mqtt.Client
+func (client *Client) ConnectionState() mqtt.ConnectionState {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return client.state
+}
+
+// OnConnectionChange implements mqtt.Client. As the connection cannot get lost,
+// callback is only run when the client is closed.
+func (client *Client) OnConnectionChange(callback mqtt.ConnectionCallback) func() {
+	w := &watcher{callback: callback}
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.watchers[w] = true
+
+	return func() {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		delete(client.watchers, w)
+	}
+}
+
+// Close implements mqtt.Client. It disconnects the client from the broker and
+// drops all of its subscriptions.
+func (client *Client) Close() {
+	client.broker.lock.Lock()
+	delete(client.broker.clients, client)
+	client.broker.lock.Unlock()
+
+	client.lock.Lock()
+	if client.closed {
+		client.lock.Unlock()
+		return
+	}
+	client.closed = true
+	client.subscriptions = make(map[*subscription]bool)
+
+	event := mqtt.ConnectionEvent{Time: time.Now(), Reason: mqtt.DisconnectRequested}
+	client.state.Connected = false
+	client.state.LastDisconnect = event.Time
+	client.state.DisconnectReason = event.Reason
+	callbacks := make([]mqtt.ConnectionCallback, 0, len(client.watchers))
+	for w := range client.watchers {
+		callbacks = append(callbacks, w.callback)
+	}
+	client.lock.Unlock()
+
+	for _, callback := range callbacks {
+		callback(event)
+	}
+}
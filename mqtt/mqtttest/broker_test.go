@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtttest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/tq-systems/public-go-utils/v3/mqtt"
+	"github.com/tq-systems/public-go-utils/v3/mqtt/test"
+)
+
+func TestBroker(t *testing.T) {
+	t.Run("Wildcard subscriptions of multiple clients", func(t *testing.T) {
+		broker := NewBroker()
+		pub := broker.NewClient("pub")
+		defer pub.Close()
+		sub := broker.NewClient("sub")
+		defer sub.Close()
+
+		received := make([]string, 0)
+		record := func(topic string, message []byte) {
+			received = append(received, topic)
+		}
+		_, err := sub.Subscribe("meters/+/power", record)
+		assert.NoError(t, err)
+		_, err = pub.Subscribe("meters/#", record)
+		assert.NoError(t, err)
+
+		assert.NoError(t, pub.PublishEmpty("meters/1/power", 0, false))
+		assert.NoError(t, pub.PublishEmpty("meters/1/energy", 0, false))
+		assert.NoError(t, pub.PublishEmpty("inverters/1/power", 0, false))
+
+		assert.ElementsMatch(t, []string{"meters/1/power", "meters/1/power", "meters/1/energy"}, received)
+	})
+
+	t.Run("Retained messages", func(t *testing.T) {
+		broker := NewBroker()
+		client := broker.NewClient("client")
+		defer client.Close()
+
+		assert.NoError(t, client.PublishRaw("config/a", 1, true, []byte("1")))
+		assert.NoError(t, client.PublishRaw("config/b", 1, true, []byte("2")))
+		assert.NoError(t, client.PublishEmpty("config/b", 1, true))
+
+		received := make(map[string]string)
+		_, err := client.Subscribe("config/+", func(topic string, message []byte) {
+			received[topic] = string(message)
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"config/a": "1"}, received)
+
+		_, ok := broker.Retained("config/b")
+		assert.False(t, ok)
+
+		message, err := client.GetRetained(context.Background(), "config/a")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("1"), message.Payload)
+		_, err = client.GetRetained(context.Background(), "config/b")
+		assert.ErrorIs(t, err, mqtt.ErrNoRetained)
+
+		assert.True(t, message.Retain)
+
+		// Retained messages are flagged, live updates are delivered with the subscribed QoS
+		messages := make([]*mqtt.Message, 0)
+		_, err = client.SubscribeMessage(context.Background(), "config/a", func(message *mqtt.Message) {
+			messages = append(messages, message)
+		}, mqtt.WithQoS(1))
+		assert.NoError(t, err)
+		assert.NoError(t, client.PublishRaw("config/a", 2, false, []byte("3")))
+		if assert.Len(t, messages, 2) {
+			assert.True(t, messages[0].Retain)
+			assert.False(t, messages[1].Retain)
+			assert.Equal(t, byte(1), messages[1].QoS)
+		}
+
+		// With retain-as-published, live updates keep the retain flag they were published with
+		published := make([]*mqtt.Message, 0)
+		_, err = client.SubscribeMessage(context.Background(), "config/c", func(message *mqtt.Message) {
+			published = append(published, message)
+		}, mqtt.WithRetainAsPublished())
+		assert.NoError(t, err)
+		assert.NoError(t, client.PublishRaw("config/c", 1, true, []byte("4")))
+		assert.NoError(t, client.PublishRaw("config/c", 1, false, []byte("5")))
+		if assert.Len(t, published, 2) {
+			assert.True(t, published[0].Retain)
+			assert.False(t, published[1].Retain)
+		}
+
+		assert.NoError(t, client.ClearRetained(context.Background(), "config/#"))
+		messages, err = client.GetRetainedPattern(context.Background(), "#")
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+	})
+
+	t.Run("Unsubscribe, no-local and close", func(t *testing.T) {
+		broker := NewBroker()
+		client := broker.NewClient("client")
+
+		count := 0
+		subscription, err := client.SubscribeMessage(context.Background(), "a", func(*mqtt.Message) {
+			count++
+		}, mqtt.WithNoLocal())
+		assert.NoError(t, err)
+
+		other := broker.NewClient("other")
+		defer other.Close()
+
+		assert.NoError(t, client.PublishEmpty("a", 0, false))
+		assert.NoError(t, other.PublishEmpty("a", 0, false))
+		assert.Equal(t, 1, count)
+
+		assert.NoError(t, subscription.UnsubscribeContext(context.Background()))
+		assert.ErrorIs(t, subscription.UnsubscribeContext(context.Background()), mqtt.ErrNotSubscribed)
+		assert.NoError(t, other.PublishEmpty("a", 0, false))
+		assert.Equal(t, 1, count)
+
+		subscription, err = client.Subscribe("b", func(string, []byte) {})
+		assert.NoError(t, err)
+		client.Close()
+		assert.ErrorIs(t, client.PublishEmpty("a", 0, false), ErrClosed)
+		assert.ErrorIs(t, subscription.UnsubscribeContext(context.Background()), mqtt.ErrClientClosed)
+
+		_, err = other.Subscribe("a/#/b", func(string, []byte) {})
+		assert.Error(t, err)
+	})
+
+	t.Run("Shared subscriptions", func(t *testing.T) {
+		broker := NewBroker()
+		pub := broker.NewClient("pub")
+		defer pub.Close()
+		assert.NoError(t, pub.PublishRaw("jobs/retained", 1, true, []byte("retained")))
+
+		received := make(map[string][]string)
+		for _, id := range []string{"worker-2", "worker-1"} {
+			worker := broker.NewClient(id)
+			defer worker.Close()
+			_, err := worker.Subscribe(mqtt.SharedFilter("workers", "jobs/+"), func(topic string, message []byte) {
+				received[id] = append(received[id], string(message))
+			})
+			assert.NoError(t, err)
+		}
+		all := make([]string, 0)
+		_, err := pub.Subscribe("jobs/+", func(topic string, message []byte) {
+			all = append(all, string(message))
+		})
+		assert.NoError(t, err)
+
+		// Each job is delivered to one worker in turn and to all non-shared subscriptions
+		for _, job := range []string{"1", "2", "3"} {
+			assert.NoError(t, pub.PublishRaw("jobs/new", 1, false, []byte(job)))
+		}
+		assert.Equal(t, map[string][]string{"worker-1": {"1", "3"}, "worker-2": {"2"}}, received)
+		assert.Equal(t, []string{"retained", "1", "2", "3"}, all)
+
+		_, err = pub.GetRetainedPattern(context.Background(), mqtt.SharedFilter("workers", "jobs/+"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Connection state", func(t *testing.T) {
+		broker := NewBroker()
+		client := broker.NewClient("client")
+		assert.True(t, client.IsConnected())
+		assert.False(t, client.ConnectionState().LastConnect.IsZero())
+
+		events := make([]mqtt.ConnectionEvent, 0)
+		client.OnConnectionChange(func(event mqtt.ConnectionEvent) {
+			events = append(events, event)
+		})
+		client.Close()
+		client.Close()
+
+		assert.False(t, client.IsConnected())
+		if assert.Len(t, events, 1) {
+			assert.False(t, events[0].Connected)
+			assert.Equal(t, mqtt.DisconnectRequested, events[0].Reason)
+			assert.Equal(t, events[0].Time, client.ConnectionState().LastDisconnect)
+		}
+	})
+
+	t.Run("RPC", func(t *testing.T) {
+		broker := NewBroker()
+		client := broker.NewClient("client")
+		defer client.Close()
+
+		server := mqtt.NewRPCServer(client)
+		defer server.Close()
+		err := mqtt.HandleRPC(server, "counter/increment", func(ctx context.Context, request *test.Test) (proto.Message, error) {
+			return &test.Test{MessageCounter: request.MessageCounter + 1}, nil
+		})
+		assert.NoError(t, err)
+
+		rpc, err := mqtt.NewRPCClient(client, "client")
+		assert.NoError(t, err)
+		defer rpc.Close()
+
+		response := &test.Test{}
+		assert.NoError(t, rpc.Call(context.Background(), "counter/increment", &test.Test{MessageCounter: 1}, response))
+		assert.Equal(t, uint64(2), response.MessageCounter)
+	})
+}
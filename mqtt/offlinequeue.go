@@ -0,0 +1,337 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tq-systems/public-go-utils/v3/log"
+)
+
+const (
+	// compactThreshold is the number of flushed messages after which the queue file is rewritten
+	compactThreshold = 100
+	// offlineQueueRetryDelay is the time to wait before retrying a failed flush
+	offlineQueueRetryDelay = time.Second
+)
+
+// ErrOfflineQueueFull is returned for publications made while disconnected if the
+// offline queue has reached one of its limits
+var ErrOfflineQueueFull = errors.New("MQTT offline queue full")
+
+// OfflineQueueConfig configures the queue for publications made while the client is disconnected
+type OfflineQueueConfig struct {
+	// MaxMessages limits the number of queued messages, 0 means no limit
+	MaxMessages int
+	// MaxBytes limits the total size of the topics and payloads of queued messages,
+	// 0 means no limit. At least one of the limits is required.
+	MaxBytes int
+	// File persists the queue, so queued messages survive a restart of the application.
+	// The queue is kept in memory only if File is empty.
+	File string
+}
+
+func (config *OfflineQueueConfig) validate() error {
+	if config == nil {
+		return nil
+	}
+	if config.MaxMessages < 0 || config.MaxBytes < 0 {
+		return errors.New("offline queue limits must not be negative")
+	}
+	if config.MaxMessages == 0 && config.MaxBytes == 0 {
+		return errors.New("offline queue requires a message or byte limit")
+	}
+	return nil
+}
+
+// A queuedPublication is a message in the offline queue, one JSON object per line in its file
+type queuedPublication struct {
+	Topic      string      `json:"topic"`
+	Payload    []byte      `json:"payload"`
+	QoS        byte        `json:"qos"`
+	Retain     bool        `json:"retain"`
+	Properties *Properties `json:"properties,omitempty"`
+}
+
+func (pub *queuedPublication) size() int {
+	return len(pub.Topic) + len(pub.Payload)
+}
+
+/* offlineQueue holds the publications made while disconnected until they are
+ * flushed after reconnecting. If it is persisted, every message is appended to
+ * the file as it is queued. Flushed messages are only removed from the file when
+ * the queue gets empty or compactThreshold messages were flushed, so after a crash
+ * up to compactThreshold messages may be published twice.
+ */
+type offlineQueue struct {
+	config   OfflineQueueConfig
+	messages []*queuedPublication
+	bytes    int
+
+	file *os.File
+	// flushed is the number of messages at the start of file that were flushed already
+	flushed int
+	// appended is the number of messages pushed since pop returned the messages to compact
+	appended int
+}
+
+// newOfflineQueue creates a queue, loading the messages persisted in config.File
+func newOfflineQueue(config OfflineQueueConfig) (*offlineQueue, error) {
+	queue := &offlineQueue{config: config}
+	if config.File == "" {
+		return queue, nil
+	}
+
+	file, err := os.OpenFile(config.File, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	queue.file = file
+
+	invalid := false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<28)
+	for scanner.Scan() {
+		pub := &queuedPublication{}
+		// A line may be incomplete if the application crashed while writing it
+		if err := json.Unmarshal(scanner.Bytes(), pub); err != nil {
+			log.Warningf("Skipping invalid message in MQTT offline queue %s: %v", config.File, err)
+			invalid = true
+			continue
+		}
+		queue.messages = append(queue.messages, pub)
+		queue.bytes += pub.size()
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if invalid {
+		var lock sync.Mutex
+		if err := queue.compact(queue.messages, &lock); err != nil {
+			queue.file.Close()
+			return nil, err
+		}
+	}
+
+	return queue, nil
+}
+
+func (queue *offlineQueue) len() int {
+	return len(queue.messages)
+}
+
+func (queue *offlineQueue) push(pub *queuedPublication) error {
+	if queue.config.MaxMessages > 0 && len(queue.messages) >= queue.config.MaxMessages {
+		return ErrOfflineQueueFull
+	}
+	if queue.config.MaxBytes > 0 && queue.bytes+pub.size() > queue.config.MaxBytes {
+		return ErrOfflineQueueFull
+	}
+
+	if queue.file != nil {
+		line, err := json.Marshal(pub)
+		if err != nil {
+			return err
+		}
+		if _, err := queue.file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		if err := queue.file.Sync(); err != nil {
+			return err
+		}
+		queue.appended++
+	}
+
+	queue.messages = append(queue.messages, pub)
+	queue.bytes += pub.size()
+
+	return nil
+}
+
+// peek returns the oldest message, nil if the queue is empty
+func (queue *offlineQueue) peek() *queuedPublication {
+	if len(queue.messages) == 0 {
+		return nil
+	}
+	return queue.messages[0]
+}
+
+// pop removes the oldest message after it was flushed. If the file is to be rewritten,
+// it returns the messages still queued for compact.
+func (queue *offlineQueue) pop() ([]*queuedPublication, bool) {
+	if len(queue.messages) == 0 {
+		return nil, false
+	}
+	queue.bytes -= queue.messages[0].size()
+	queue.messages[0] = nil
+	queue.messages = queue.messages[1:]
+
+	if queue.file == nil {
+		return nil, false
+	}
+	queue.flushed++
+	if len(queue.messages) > 0 && queue.flushed < compactThreshold {
+		return nil, false
+	}
+	queue.appended = 0
+	return slices.Clone(queue.messages), true
+}
+
+/* compact replaces the file with snapshot, the messages returned by pop. The new file
+ * is written without holding lock, which guards the queue, so publishing is not blocked
+ * meanwhile. The messages pushed since pop are added to it before it replaces the file.
+ */
+func (queue *offlineQueue) compact(snapshot []*queuedPublication, lock *sync.Mutex) error {
+	// Write a new file and rename it, so the queue is never lost
+	tmp, err := os.CreateTemp(filepath.Dir(queue.config.File), filepath.Base(queue.config.File)+".*")
+	if err != nil {
+		return err
+	}
+	err = writeQueued(tmp, snapshot)
+	if err == nil {
+		locked(lock, func() {
+			err = writeQueued(tmp, queue.messages[len(queue.messages)-queue.appended:])
+			if err == nil {
+				err = os.Rename(tmp.Name(), queue.config.File)
+			}
+			if err == nil {
+				queue.file.Close()
+				queue.file = tmp
+				queue.flushed = 0
+				queue.appended = 0
+			}
+		})
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// writeQueued writes messages to file like push and syncs it
+func writeQueued(file *os.File, messages []*queuedPublication) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for _, pub := range messages {
+		if err := encoder.Encode(pub); err != nil {
+			return err
+		}
+	}
+	if _, err := file.Write(data.Bytes()); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func (queue *offlineQueue) close() {
+	if queue != nil && queue.file != nil {
+		queue.file.Close()
+	}
+}
+
+/* enqueue adds pub to the offline queue of the client if the client is disconnected or
+ * the queue is not empty, and returns whether it did. An error is returned if pub is
+ * invalid, the queue is full or the client is closed.
+ */
+func (client *client) enqueue(pub *queuedPublication) (bool, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	// Nothing flushes the queue anymore
+	if client.closing {
+		return true, ErrClientClosed
+	}
+	if client.connected && client.queue.len() == 0 {
+		return false, nil
+	}
+	// Invalid messages would block the queue
+	if !ValidTopicName(pub.Topic) || pub.QoS > 2 {
+		return true, fmt.Errorf("failed to publish message: invalid topic '%s' or QoS %d", pub.Topic, pub.QoS)
+	}
+
+	queued := *pub
+	queued.Payload = bytes.Clone(pub.Payload)
+	if err := client.queue.push(&queued); err != nil {
+		return true, err
+	}
+	client.connectedCond.Broadcast()
+
+	return true, nil
+}
+
+// flushOfflineQueue publishes the queued messages in order whenever the client is
+// connected, until ctx is done or the client is closed
+func (client *client) flushOfflineQueue(ctx context.Context) {
+	defer client.flusher.Done()
+
+	for {
+		var pub *queuedPublication
+		locked(client.lock, func() {
+			for !client.closing && (!client.connected || client.queue.len() == 0) {
+				client.connectedCond.Wait()
+			}
+			if !client.closing {
+				pub = client.queue.peek()
+			}
+		})
+		if pub == nil {
+			return
+		}
+
+		err := client.doPublish(ctx, pub.Topic, pub.QoS, pub.Retain, pub.Payload, pub.Properties, pub.QoS > 0)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warningf("Unable to publish queued MQTT message to topic %s: %v", pub.Topic, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(offlineQueueRetryDelay):
+			}
+			continue
+		}
+
+		var queue *offlineQueue
+		var snapshot []*queuedPublication
+		compact := false
+		locked(client.lock, func() {
+			queue = client.queue
+			snapshot, compact = queue.pop()
+		})
+		if compact {
+			if err := queue.compact(snapshot, client.lock); err != nil {
+				log.Errorf("Unable to update MQTT offline queue %s: %v", queue.config.File, err)
+			}
+		}
+	}
+}
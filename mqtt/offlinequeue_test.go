@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func queuedPayloads(queue *offlineQueue) []string {
+	payloads := make([]string, 0)
+	for _, pub := range queue.messages {
+		payloads = append(payloads, string(pub.Payload))
+	}
+	return payloads
+}
+
+// pop removes the oldest message of queue and compacts its file like the client does
+func pop(t *testing.T, queue *offlineQueue) {
+	if snapshot, compact := queue.pop(); compact {
+		assert.Nil(t, queue.compact(snapshot, &sync.Mutex{}))
+	}
+}
+
+func TestOfflineQueue(t *testing.T) {
+	t.Run("Limits", func(t *testing.T) {
+		queue, err := newOfflineQueue(OfflineQueueConfig{MaxMessages: 2})
+		assert.Nil(t, err)
+		assert.Nil(t, queue.push(&queuedPublication{Topic: topic, Payload: []byte("1")}))
+		assert.Nil(t, queue.push(&queuedPublication{Topic: topic, Payload: []byte("2")}))
+		assert.ErrorIs(t, queue.push(&queuedPublication{Topic: topic, Payload: []byte("3")}), ErrOfflineQueueFull)
+
+		pop(t, queue)
+		assert.Nil(t, queue.push(&queuedPublication{Topic: topic, Payload: []byte("3")}))
+		assert.Equal(t, []string{"2", "3"}, queuedPayloads(queue))
+
+		// Topic and payload count
+		queue, err = newOfflineQueue(OfflineQueueConfig{MaxBytes: 2 * len(topic)})
+		assert.Nil(t, err)
+		assert.Nil(t, queue.push(&queuedPublication{Topic: topic}))
+		assert.ErrorIs(t, queue.push(&queuedPublication{Topic: topic, Payload: []byte("1")}), ErrOfflineQueueFull)
+		assert.Nil(t, queue.push(&queuedPublication{Topic: topic}))
+	})
+
+	t.Run("Persistence", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "queue")
+		config := OfflineQueueConfig{MaxMessages: 10, File: file}
+
+		queue, err := newOfflineQueue(config)
+		assert.Nil(t, err)
+		for _, payload := range []string{"1", "2", "3"} {
+			assert.Nil(t, queue.push(&queuedPublication{Topic: topic, Payload: []byte(payload), QoS: 1,
+				Properties: &Properties{ContentType: "text/plain"}}))
+		}
+		queue.close()
+
+		// An incomplete line written before a crash is skipped
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0600)
+		assert.Nil(t, err)
+		_, err = f.WriteString(`{"topic":"TOP`)
+		assert.Nil(t, err)
+		f.Close()
+
+		queue, err = newOfflineQueue(config)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1", "2", "3"}, queuedPayloads(queue))
+		assert.Equal(t, byte(1), queue.peek().QoS)
+		assert.Equal(t, "text/plain", queue.peek().Properties.ContentType)
+
+		// Flushed messages are removed from the file when the queue is empty
+		pop(t, queue)
+		assert.Nil(t, queue.push(&queuedPublication{Topic: topic, Payload: []byte("4")}))
+		queue.close()
+
+		queue, err = newOfflineQueue(config)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1", "2", "3", "4"}, queuedPayloads(queue))
+		for queue.len() > 0 {
+			pop(t, queue)
+		}
+		assert.Nil(t, queue.push(&queuedPublication{Topic: topic, Payload: []byte("5")}))
+		queue.close()
+
+		queue, err = newOfflineQueue(config)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"5"}, queuedPayloads(queue))
+		queue.close()
+	})
+
+	t.Run("Compaction", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "queue")
+		config := OfflineQueueConfig{MaxMessages: 2 * compactThreshold, File: file}
+
+		queue, err := newOfflineQueue(config)
+		assert.Nil(t, err)
+		for i := range compactThreshold + 1 {
+			assert.Nil(t, queue.push(&queuedPublication{Topic: topic, Payload: []byte(fmt.Sprint(i))}))
+		}
+		for range compactThreshold - 1 {
+			pop(t, queue)
+		}
+		snapshot, compact := queue.pop()
+		assert.True(t, compact)
+		// Messages pushed while the file is rewritten are kept
+		assert.Nil(t, queue.push(&queuedPublication{Topic: topic, Payload: []byte("last")}))
+		assert.Nil(t, queue.compact(snapshot, &sync.Mutex{}))
+		queue.close()
+
+		queue, err = newOfflineQueue(config)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{fmt.Sprint(compactThreshold), "last"}, queuedPayloads(queue))
+		queue.close()
+	})
+
+	t.Run("Flush after reconnect", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		port := broker.port()
+		c, err := NewClientWithOptions("127.0.0.1", port, "client", WithPureGo(),
+			WithOfflineQueue(OfflineQueueConfig{MaxMessages: 3, File: filepath.Join(t.TempDir(), "queue")}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		broker.stop()
+		assert.Eventually(t, func() bool {
+			c.(*client).lock.Lock()
+			defer c.(*client).lock.Unlock()
+			return !c.(*client).connected
+		}, time.Second, 10*time.Millisecond)
+
+		for _, payload := range []string{"1", "2", "3"} {
+			assert.Nil(t, c.PublishRaw(topic, 1, false, []byte(payload)))
+		}
+		assert.ErrorIs(t, c.PublishRaw(topic, 1, false, []byte("4")), ErrOfflineQueueFull)
+		assert.NotNil(t, c.PublishRaw("invalid/#", 1, false, []byte("5")))
+
+		broker = startScriptBroker(t, "tcp", fmt.Sprintf("127.0.0.1:%d", port), 0)
+		assert.Eventually(t, func() bool {
+			return len(broker.publishedPayloads()) == 3
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{"1", "2", "3"}, broker.publishedPayloads())
+
+		// Later messages are published after the queued ones
+		assert.Nil(t, c.PublishRaw(topic, 1, false, []byte("6")))
+		assert.Eventually(t, func() bool {
+			return len(broker.publishedPayloads()) == 4
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{"1", "2", "3", "6"}, broker.publishedPayloads())
+	})
+
+	t.Run("Publish after Close", func(t *testing.T) {
+		broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+		for _, file := range []string{"", filepath.Join(t.TempDir(), "queue")} {
+			c, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo(),
+				WithOfflineQueue(OfflineQueueConfig{MaxMessages: 3, File: file}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Close()
+
+			assert.ErrorIs(t, c.PublishRaw(topic, 1, false, []byte("lost")), ErrClientClosed, file)
+		}
+		assert.Empty(t, broker.publishedPayloads())
+	})
+}
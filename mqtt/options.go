@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultKeepalive = 10 * time.Second
+	// defaultReconnectDelay is the time between connection attempts, like in libmosquitto
+	defaultReconnectDelay = time.Second
+)
+
+// An Option configures a Client created by NewClientWithOptions or NewClientContext
+type Option func(*options)
+
+// TLSConfig holds the certificate based TLS settings of a connection
+type TLSConfig struct {
+	// CAFile and/or CAPath point to the trusted CA certificates; at least one is required
+	CAFile string
+	CAPath string
+	// CertFile and KeyFile are the (optional) client certificate and its unencrypted private key
+	CertFile string
+	KeyFile  string
+	// Version is the TLS version, e.g. "tlsv1.2"; the default of the backend is used if empty
+	Version string
+	// Ciphers is an OpenSSL cipher list; the default of libmosquitto is used if empty.
+	// It is not supported by the pure-Go backend.
+	Ciphers string
+	// Insecure disables the verification of the server hostname in the server certificate
+	Insecure bool
+}
+
+// PSKConfig holds the settings for TLS with a pre-shared key
+type PSKConfig struct {
+	// PSK is the pre-shared key in hex format without leading "0x"
+	PSK      string
+	Identity string
+	// Ciphers is an OpenSSL cipher list; the default of libmosquitto is used if empty
+	Ciphers string
+}
+
+// A BrokerAddress is the address of a broker to connect to, given like to NewClient
+type BrokerAddress struct {
+	Address string
+	Port    int
+}
+
+// A publication is a message configured in advance, like the will or birth message
+type publication struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+}
+
+type options struct {
+	keepalive    time.Duration
+	cleanSession bool
+	username     string
+	password     string
+	tls          *TLSConfig
+	psk          *PSKConfig
+	will         *publication
+	birth        *publication
+	dispatch     *dispatchOptions
+	protocolV5   bool
+	pureGo       bool
+	offlineQueue *OfflineQueueConfig
+	metrics      MetricsCollector
+	// Middlewares in the order given, the first one outermost
+	publishMiddleware  []PublishMiddleware
+	callbackMiddleware []CallbackMiddleware
+	// reconnectDelay is doubled after every failed attempt, up to maxReconnectDelay
+	reconnectDelay    time.Duration
+	maxReconnectDelay time.Duration
+	background        bool
+	fallbackBrokers   []BrokerAddress
+}
+
+func defaultOptions() *options {
+	return &options{
+		keepalive:         defaultKeepalive,
+		cleanSession:      true,
+		reconnectDelay:    defaultReconnectDelay,
+		maxReconnectDelay: defaultReconnectDelay,
+	}
+}
+
+func (opts *options) validate() error {
+	if opts.tls != nil && opts.psk != nil {
+		return errors.New("certificate based TLS and TLS-PSK cannot be used at the same time")
+	}
+	if opts.tls != nil && opts.tls.CAFile == "" && opts.tls.CAPath == "" {
+		return errors.New("TLS requires a CA file or CA path")
+	}
+	if opts.tls != nil && (opts.tls.CertFile == "") != (opts.tls.KeyFile == "") {
+		return errors.New("TLS client certificate and key file must be given together")
+	}
+	if opts.psk != nil && (opts.psk.PSK == "" || opts.psk.Identity == "") {
+		return errors.New("TLS-PSK requires a key and an identity")
+	}
+	if len(opts.username) > maxStringLength || len(opts.password) > maxStringLength {
+		return fmt.Errorf("username and password must not be longer than %d bytes", maxStringLength)
+	}
+	if err := opts.will.validate(); err != nil {
+		return fmt.Errorf("invalid will: %w", err)
+	}
+	// Unlike the payload of a PUBLISH packet, the will payload is prefixed with its length
+	if opts.will != nil && len(opts.will.payload) > maxStringLength {
+		return fmt.Errorf("invalid will: payload longer than %d bytes", maxStringLength)
+	}
+	if err := opts.birth.validate(); err != nil {
+		return fmt.Errorf("invalid birth message: %w", err)
+	}
+	if opts.dispatch != nil && opts.dispatch.queueSize < 1 {
+		return fmt.Errorf("invalid dispatch queue size %d", opts.dispatch.queueSize)
+	}
+	if err := opts.offlineQueue.validate(); err != nil {
+		return err
+	}
+	if opts.dispatch != nil && (opts.dispatch.policy < DropOldest || opts.dispatch.policy > Block) {
+		return fmt.Errorf("invalid overflow policy %v", opts.dispatch.policy)
+	}
+	if opts.reconnectDelay <= 0 || opts.maxReconnectDelay < opts.reconnectDelay {
+		return fmt.Errorf("invalid reconnect delay %v (maximum %v)", opts.reconnectDelay, opts.maxReconnectDelay)
+	}
+	for _, broker := range opts.fallbackBrokers {
+		if broker.Address == "" || broker.Port < 0 {
+			return fmt.Errorf("invalid fallback broker %s:%d", broker.Address, broker.Port)
+		}
+	}
+	return nil
+}
+
+func (pub *publication) validate() error {
+	if pub == nil {
+		return nil
+	}
+	if pub.topic == "" || strings.ContainsAny(pub.topic, "+#") {
+		return fmt.Errorf("invalid topic '%s'", pub.topic)
+	}
+	if len(pub.topic) > maxStringLength {
+		return fmt.Errorf("topic longer than %d bytes", maxStringLength)
+	}
+	if pub.qos > 2 {
+		return fmt.Errorf("invalid QoS %d", pub.qos)
+	}
+	return nil
+}
+
+// WithKeepalive sets the keepalive interval of the connection (default 10 seconds).
+// The interval is rounded down to full seconds.
+func WithKeepalive(keepalive time.Duration) Option {
+	return func(opts *options) {
+		opts.keepalive = keepalive
+	}
+}
+
+// WithCleanSession controls whether the broker discards the session of the client
+// when it disconnects (default true). Persistent sessions need a unique client ID.
+func WithCleanSession(cleanSession bool) Option {
+	return func(opts *options) {
+		opts.cleanSession = cleanSession
+	}
+}
+
+// WithCredentials sets username and password used to authenticate at the broker
+func WithCredentials(username string, password string) Option {
+	return func(opts *options) {
+		opts.username = username
+		opts.password = password
+	}
+}
+
+// WithTLS secures the connection with certificate based TLS
+func WithTLS(config TLSConfig) Option {
+	return func(opts *options) {
+		opts.tls = &config
+	}
+}
+
+// WithTLSPSK secures the connection with TLS using a pre-shared key
+func WithTLSPSK(config PSKConfig) Option {
+	return func(opts *options) {
+		opts.psk = &config
+	}
+}
+
+// WithWill sets the Last Will and Testament of the client: the broker publishes
+// the given message on behalf of the client when the connection is lost without
+// a proper disconnect, e.g. because the application crashed.
+func WithWill(topic string, payload []byte, qos byte, retain bool) Option {
+	return func(opts *options) {
+		opts.will = &publication{topic: topic, payload: payload, qos: qos, retain: retain}
+	}
+}
+
+// WithBirthMessage sets a message that is published every time the connection
+// to the broker is (re)established, after the subscriptions have been restored.
+// Together with WithWill, this allows others to track whether the client is online.
+func WithBirthMessage(topic string, payload []byte, qos byte, retain bool) Option {
+	return func(opts *options) {
+		opts.birth = &publication{topic: topic, payload: payload, qos: qos, retain: retain}
+	}
+}
+
+// WithAsyncDispatch runs the callbacks of each subscription in a goroutine of its
+// own instead of the network thread. Every subscription gets a queue holding up
+// to queueSize messages; policy decides what happens to messages when it is full.
+// Messages of one subscription are still delivered in order.
+func WithAsyncDispatch(queueSize int, policy OverflowPolicy) Option {
+	return func(opts *options) {
+		opts.dispatch = &dispatchOptions{queueSize: queueSize, policy: policy}
+	}
+}
+
+// WithProtocolV5 connects to the broker using MQTT v5 instead of MQTT 3.1.1,
+// which is required for message properties and subscription options
+func WithProtocolV5() Option {
+	return func(opts *options) {
+		opts.protocolV5 = true
+	}
+}
+
+// WithPureGo uses the pure-Go implementation of MQTT 3.1.1 instead of libmosquitto.
+// It is the default if the package is built without cgo or with the build tag
+// mqtt_purego. The pure-Go backend supports neither MQTT v5 nor TLS-PSK, and it drops
+// the connection when the broker sends a packet larger than 16 MiB.
+func WithPureGo() Option {
+	return func(opts *options) {
+		opts.pureGo = true
+	}
+}
+
+// WithOfflineQueue queues publications made while the client is disconnected and
+// publishes them in order after reconnecting, instead of failing. Publications made
+// before the queue is flushed completely are queued as well, so the order is kept.
+func WithOfflineQueue(config OfflineQueueConfig) Option {
+	return func(opts *options) {
+		opts.offlineQueue = &config
+	}
+}
+
+// WithReconnectDelay sets the time to wait before reconnecting after the connection was
+// lost. It grows after every failed attempt up to maxDelay, and is reset once a connection
+// was established. The pure-Go backend doubles it, libmosquitto applies its own backoff
+// in whole seconds. The default is 1 second without backoff.
+func WithReconnectDelay(delay time.Duration, maxDelay time.Duration) Option {
+	return func(opts *options) {
+		opts.reconnectDelay = delay
+		opts.maxReconnectDelay = maxDelay
+	}
+}
+
+// WithBackgroundConnect makes NewClient return without waiting for the connection,
+// even if the broker cannot be reached; the client keeps trying to connect in the
+// background. Subscriptions made while disconnected are sent to the broker once
+// connected, publications are only kept with QoS > 0 or WithOfflineQueue.
+func WithBackgroundConnect() Option {
+	return func(opts *options) {
+		opts.background = true
+	}
+}
+
+// WithFallbackBrokers sets brokers to try in order if the broker given to NewClient
+// cannot be reached. With libmosquitto, they are only tried when connecting initially;
+// after the connection was lost, it reconnects to the same broker.
+func WithFallbackBrokers(brokers ...BrokerAddress) Option {
+	return func(opts *options) {
+		opts.fallbackBrokers = brokers
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		opts := defaultOptions()
+		assert.Equal(t, 10*time.Second, opts.keepalive)
+		assert.True(t, opts.cleanSession)
+		assert.NoError(t, opts.validate())
+	})
+
+	tests := []struct {
+		name  string
+		opts  []Option
+		valid bool
+	}{
+		{"Credentials", []Option{WithCredentials("user", "secret")}, true},
+		{"Persistent session", []Option{WithCleanSession(false), WithKeepalive(time.Minute)}, true},
+		{"TLS with CA file", []Option{WithTLS(TLSConfig{CAFile: "ca.crt"})}, true},
+		{"TLS with client certificate", []Option{WithTLS(TLSConfig{CAPath: "/etc/ssl/certs", CertFile: "c.crt", KeyFile: "c.key"})}, true},
+		{"TLS without CA", []Option{WithTLS(TLSConfig{})}, false},
+		{"TLS with certificate but no key", []Option{WithTLS(TLSConfig{CAFile: "ca.crt", CertFile: "c.crt"})}, false},
+		{"PSK", []Option{WithTLSPSK(PSKConfig{PSK: "deadbeef", Identity: "em"})}, true},
+		{"PSK without identity", []Option{WithTLSPSK(PSKConfig{PSK: "deadbeef"})}, false},
+		{"TLS and PSK", []Option{WithTLS(TLSConfig{CAFile: "ca.crt"}), WithTLSPSK(PSKConfig{PSK: "deadbeef", Identity: "em"})}, false},
+		{"Will", []Option{WithWill("app/status", []byte("offline"), 1, true)}, true},
+		{"Will with wildcard topic", []Option{WithWill("app/#", []byte("offline"), 1, true)}, false},
+		{"Will with oversized payload", []Option{WithWill("app/status", make([]byte, 65536), 1, true)}, false},
+		{"Birth message with oversized topic", []Option{WithBirthMessage(strings.Repeat("a", 65536), nil, 1, true)}, false},
+		{"Oversized password", []Option{WithCredentials("user", strings.Repeat("a", 65536))}, false},
+		{"Birth message", []Option{WithBirthMessage("app/status", []byte("online"), 1, true)}, true},
+		{"Birth message with invalid QoS", []Option{WithBirthMessage("app/status", []byte("online"), 3, true)}, false},
+		{"Offline queue", []Option{WithOfflineQueue(OfflineQueueConfig{MaxBytes: 1 << 20, File: "/tmp/queue"})}, true},
+		{"Offline queue without limit", []Option{WithOfflineQueue(OfflineQueueConfig{})}, false},
+		{"Offline queue with negative limit", []Option{WithOfflineQueue(OfflineQueueConfig{MaxMessages: -1, MaxBytes: 10})}, false},
+		{"Reconnect backoff", []Option{WithReconnectDelay(time.Second, time.Minute)}, true},
+		{"Reconnect delay above maximum", []Option{WithReconnectDelay(time.Minute, time.Second)}, false},
+		{"Zero reconnect delay", []Option{WithReconnectDelay(0, time.Second)}, false},
+		{"Fallback brokers", []Option{WithFallbackBrokers(BrokerAddress{"backup", 1883}), WithBackgroundConnect()}, true},
+		{"Fallback broker without address", []Option{WithFallbackBrokers(BrokerAddress{Port: 1883})}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultOptions()
+			for _, opt := range tt.opts {
+				opt(opts)
+			}
+			if tt.valid {
+				assert.NoError(t, opts.validate())
+			} else {
+				assert.Error(t, opts.validate())
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/* This file implements the encoding of the MQTT 3.1.1 control packets used by
+ * the pure-Go backend. Every packet starts with a fixed header: one byte holding
+ * the packet type (upper nibble) and flags (lower nibble), followed by the length
+ * of the rest of the packet as a variable byte integer.
+ */
+
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetPubrec      byte = 5
+	packetPubrel      byte = 6
+	packetPubcomp     byte = 7
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsuback    byte = 11
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+
+	// protocolLevel311 is the protocol level of MQTT 3.1.1 in the CONNECT packet
+	protocolLevel311 byte = 4
+	// subackFailure is the return code of a rejected subscription in a SUBACK packet
+	subackFailure byte = 0x80
+
+	// maxPacketSize limits the length of received packets, which MQTT allows up to
+	// 256 MiB, so that a broker cannot make the client allocate arbitrary amounts of memory
+	maxPacketSize = 16 * 1024 * 1024
+	// maxStringLength limits strings and binary data, which are prefixed with their length
+	// as a two byte integer
+	maxStringLength = 65535
+)
+
+var (
+	errMalformedPacket = errors.New("malformed MQTT packet")
+	errPacketTooLarge  = fmt.Errorf("MQTT packet larger than %d bytes", maxPacketSize)
+	errStringTooLong   = fmt.Errorf("MQTT string longer than %d bytes", maxStringLength)
+)
+
+// A packet is a raw MQTT control packet
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// connackErrors are the descriptions of the return codes of a refused CONNACK
+var connackErrors = map[byte]string{
+	1: "Connection Refused: unacceptable protocol version",
+	2: "Connection Refused: identifier rejected",
+	3: "Connection Refused: broker unavailable",
+	4: "Connection Refused: bad user name or password",
+	5: "Connection Refused: not authorised",
+}
+
+// connackError returns the error for a CONNACK return code, nil if the connection was accepted
+func connackError(code byte) error {
+	if code == 0 {
+		return nil
+	}
+	if description, ok := connackErrors[code]; ok {
+		return errors.New(description)
+	}
+	return fmt.Errorf("Connection Refused: unknown reason %d", code)
+}
+
+// encode returns the packet including its fixed header
+func (p *packet) encode() []byte {
+	data := make([]byte, 0, len(p.body)+5)
+	data = append(data, p.kind<<4|p.flags)
+	length := len(p.body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		data = append(data, digit)
+		if length == 0 {
+			break
+		}
+	}
+	return append(data, p.body...)
+}
+
+// readPacket reads the next packet from r, failing for packets longer than maxPacketSize
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	length := 0
+	for multiplier := 1; ; multiplier *= 128 {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		if multiplier == 128*128*128 {
+			return nil, errMalformedPacket
+		}
+	}
+
+	if length > maxPacketSize {
+		return nil, errPacketTooLarge
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return &packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+func appendString(data []byte, s string) ([]byte, error) {
+	return appendBytes(data, []byte(s))
+}
+
+// appendBytes appends b prefixed with its length, failing if it is longer than maxStringLength
+func appendBytes(data []byte, b []byte) ([]byte, error) {
+	if len(b) > maxStringLength {
+		return nil, errStringTooLong
+	}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(b)))
+	return append(data, b...), nil
+}
+
+// readString reads a length-prefixed string from data and returns it with the rest of data
+func readString(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, errMalformedPacket
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+length {
+		return "", nil, errMalformedPacket
+	}
+	return string(data[2 : 2+length]), data[2+length:], nil
+}
+
+// A connectPacket holds the contents of a CONNECT packet
+type connectPacket struct {
+	clientID     string
+	cleanSession bool
+	keepalive    uint16
+	username     string
+	password     string
+	will         *publication
+}
+
+func (c *connectPacket) encode() ([]byte, error) {
+	var flags byte
+	if c.cleanSession {
+		flags |= 0x02
+	}
+	if c.will != nil {
+		flags |= 0x04 | c.will.qos<<3
+		if c.will.retain {
+			flags |= 0x20
+		}
+	}
+	if c.username != "" || c.password != "" {
+		flags |= 0x80
+	}
+	if c.password != "" {
+		flags |= 0x40
+	}
+
+	body, _ := appendString(nil, "MQTT")
+	body = append(body, protocolLevel311, flags)
+	body = binary.BigEndian.AppendUint16(body, c.keepalive)
+	fields := []string{c.clientID}
+	if c.will != nil {
+		fields = append(fields, c.will.topic, string(c.will.payload))
+	}
+	if flags&0x80 != 0 {
+		fields = append(fields, c.username)
+	}
+	if flags&0x40 != 0 {
+		fields = append(fields, c.password)
+	}
+	for _, s := range fields {
+		var err error
+		if body, err = appendString(body, s); err != nil {
+			return nil, err
+		}
+	}
+
+	return (&packet{kind: packetConnect, body: body}).encode(), nil
+}
+
+// decodeConnack returns the return code and the session present flag of a CONNACK packet
+func decodeConnack(p *packet) (byte, bool, error) {
+	if p.kind != packetConnack || len(p.body) != 2 {
+		return 0, false, errMalformedPacket
+	}
+	return p.body[1], p.body[0]&0x01 != 0, nil
+}
+
+// A publishPacket holds the contents of a PUBLISH packet
+type publishPacket struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+	dup     bool
+	// id is the packet identifier, only used for QoS > 0
+	id uint16
+}
+
+func (pub *publishPacket) encode() ([]byte, error) {
+	flags := pub.qos << 1
+	if pub.retain {
+		flags |= 0x01
+	}
+	if pub.dup {
+		flags |= 0x08
+	}
+
+	body, err := appendString(make([]byte, 0, len(pub.topic)+len(pub.payload)+4), pub.topic)
+	if err != nil {
+		return nil, err
+	}
+	if pub.qos > 0 {
+		body = binary.BigEndian.AppendUint16(body, pub.id)
+	}
+	body = append(body, pub.payload...)
+
+	return (&packet{kind: packetPublish, flags: flags, body: body}).encode(), nil
+}
+
+func decodePublish(p *packet) (*publishPacket, error) {
+	pub := &publishPacket{
+		qos:    (p.flags >> 1) & 0x03,
+		retain: p.flags&0x01 != 0,
+		dup:    p.flags&0x08 != 0,
+	}
+	if pub.qos > 2 {
+		return nil, errMalformedPacket
+	}
+
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return nil, err
+	}
+	pub.topic = topic
+	if pub.qos > 0 {
+		if len(rest) < 2 {
+			return nil, errMalformedPacket
+		}
+		pub.id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	pub.payload = rest
+
+	return pub, nil
+}
+
+// encodeAck encodes the packets consisting of a packet identifier only:
+// PUBACK, PUBREC, PUBREL, PUBCOMP and UNSUBACK
+func encodeAck(kind byte, id uint16) []byte {
+	var flags byte
+	if kind == packetPubrel {
+		flags = 0x02
+	}
+	return (&packet{kind: kind, flags: flags, body: binary.BigEndian.AppendUint16(nil, id)}).encode()
+}
+
+// decodeID returns the packet identifier at the start of a packet body
+func decodeID(p *packet) (uint16, error) {
+	if len(p.body) < 2 {
+		return 0, errMalformedPacket
+	}
+	return binary.BigEndian.Uint16(p.body), nil
+}
+
+// encodeSubscribe encodes a SUBSCRIBE packet requesting qos for all topics
+func encodeSubscribe(id uint16, topics []string, qos byte) ([]byte, error) {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	for _, topic := range topics {
+		var err error
+		if body, err = appendString(body, topic); err != nil {
+			return nil, err
+		}
+		body = append(body, qos)
+	}
+	return (&packet{kind: packetSubscribe, flags: 0x02, body: body}).encode(), nil
+}
+
+func encodeUnsubscribe(id uint16, topics []string) ([]byte, error) {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	for _, topic := range topics {
+		var err error
+		if body, err = appendString(body, topic); err != nil {
+			return nil, err
+		}
+	}
+	return (&packet{kind: packetUnsubscribe, flags: 0x02, body: body}).encode(), nil
+}
+
+// encodeEmpty encodes the packets without a body: PINGREQ, PINGRESP and DISCONNECT
+func encodeEmpty(kind byte) []byte {
+	return (&packet{kind: kind}).encode()
+}
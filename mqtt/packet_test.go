@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodePacket(t *testing.T, data []byte) *packet {
+	p, err := readPacket(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// encode returns the packet encoded by encode, failing the test on errors
+func encode(t *testing.T, encode func() ([]byte, error)) []byte {
+	data, err := encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPackets(t *testing.T) {
+	t.Run("Remaining length", func(t *testing.T) {
+		lengths := []struct {
+			length int
+			header []byte
+		}{
+			{0, []byte{0x30, 0x00}},
+			{127, []byte{0x30, 0x7f}},
+			{128, []byte{0x30, 0x80, 0x01}},
+			{16383, []byte{0x30, 0xff, 0x7f}},
+			{16384, []byte{0x30, 0x80, 0x80, 0x01}},
+			{2097152, []byte{0x30, 0x80, 0x80, 0x80, 0x01}},
+		}
+
+		for _, tt := range lengths {
+			data := (&packet{kind: packetPublish, body: make([]byte, tt.length)}).encode()
+			assert.Equal(t, tt.header, data[:len(tt.header)])
+			assert.Equal(t, tt.length, len(decodePacket(t, data).body))
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := readPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0x80, 0x80, 0x80, 0x80, 0x01})))
+		assert.ErrorIs(t, err, errMalformedPacket)
+
+		_, err = readPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0x05, 0x00})))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+		// The largest length MQTT allows is rejected before reading the body
+		_, err = readPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0x7f})))
+		assert.ErrorIs(t, err, errPacketTooLarge)
+
+		_, err = decodePublish(&packet{kind: packetPublish, body: []byte{0x00, 0x05, 'a'}})
+		assert.ErrorIs(t, err, errMalformedPacket)
+
+		_, err = decodePublish(&packet{kind: packetPublish, flags: 0x06, body: []byte{0x00, 0x01, 'a'}})
+		assert.ErrorIs(t, err, errMalformedPacket)
+	})
+
+	t.Run("CONNECT", func(t *testing.T) {
+		connect := &connectPacket{
+			clientID:     "id",
+			cleanSession: true,
+			keepalive:    10,
+			username:     "u",
+			password:     "p",
+			will:         &publication{topic: "w", payload: []byte("x"), qos: 1, retain: true},
+		}
+		assert.Equal(t, []byte{
+			0x10, 26,
+			0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0xee, 0x00, 0x0a,
+			0x00, 0x02, 'i', 'd',
+			0x00, 0x01, 'w', 0x00, 0x01, 'x',
+			0x00, 0x01, 'u',
+			0x00, 0x01, 'p',
+		}, encode(t, connect.encode))
+
+		anonymous := &connectPacket{clientID: "id"}
+		assert.Equal(t, []byte{
+			0x10, 14,
+			0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x00, 0x00, 0x00,
+			0x00, 0x02, 'i', 'd',
+		}, encode(t, anonymous.encode))
+
+		// Strings are prefixed with their length as a two byte integer
+		long := string(make([]byte, maxStringLength+1))
+		_, err := (&connectPacket{clientID: "id", password: long}).encode()
+		assert.ErrorIs(t, err, errStringTooLong)
+		_, err = (&connectPacket{clientID: "id", will: &publication{topic: "w", payload: []byte(long)}}).encode()
+		assert.ErrorIs(t, err, errStringTooLong)
+	})
+
+	t.Run("CONNACK", func(t *testing.T) {
+		code, sessionPresent, err := decodeConnack(decodePacket(t, []byte{0x20, 0x02, 0x00, 0x05}))
+		assert.Nil(t, err)
+		assert.Equal(t, byte(5), code)
+		assert.False(t, sessionPresent)
+		assert.EqualError(t, connackError(code), "Connection Refused: not authorised")
+		assert.Nil(t, connackError(0))
+
+		_, sessionPresent, err = decodeConnack(decodePacket(t, []byte{0x20, 0x02, 0x01, 0x00}))
+		assert.Nil(t, err)
+		assert.True(t, sessionPresent)
+
+		_, _, err = decodeConnack(decodePacket(t, []byte{0x90, 0x02, 0x00, 0x05}))
+		assert.ErrorIs(t, err, errMalformedPacket)
+	})
+
+	t.Run("PUBLISH", func(t *testing.T) {
+		publish := &publishPacket{topic: "a/b", payload: []byte("msg"), qos: 2, retain: true, dup: true, id: 0x0102}
+		data := encode(t, publish.encode)
+		assert.Equal(t, []byte{0x3d, 10, 0x00, 0x03, 'a', '/', 'b', 0x01, 0x02, 'm', 's', 'g'}, data)
+
+		decoded, err := decodePublish(decodePacket(t, data))
+		assert.Nil(t, err)
+		assert.Equal(t, publish, decoded)
+
+		// QoS 0 publications have no packet identifier
+		data = encode(t, (&publishPacket{topic: "a", payload: []byte{}}).encode)
+		assert.Equal(t, []byte{0x30, 3, 0x00, 0x01, 'a'}, data)
+		decoded, err = decodePublish(decodePacket(t, data))
+		assert.Nil(t, err)
+		assert.Equal(t, "a", decoded.topic)
+		assert.Empty(t, decoded.payload)
+
+		_, err = (&publishPacket{topic: string(make([]byte, maxStringLength+1))}).encode()
+		assert.ErrorIs(t, err, errStringTooLong)
+	})
+
+	t.Run("Acknowledgements", func(t *testing.T) {
+		assert.Equal(t, []byte{0x40, 0x02, 0x12, 0x34}, encodeAck(packetPuback, 0x1234))
+		assert.Equal(t, []byte{0x62, 0x02, 0x12, 0x34}, encodeAck(packetPubrel, 0x1234))
+
+		id, err := decodeID(decodePacket(t, []byte{0x90, 0x03, 0x12, 0x34, 0x02}))
+		assert.Nil(t, err)
+		assert.Equal(t, uint16(0x1234), id)
+
+		_, err = decodeID(decodePacket(t, []byte{0xb0, 0x01, 0x12}))
+		assert.ErrorIs(t, err, errMalformedPacket)
+	})
+
+	t.Run("SUBSCRIBE and UNSUBSCRIBE", func(t *testing.T) {
+		assert.Equal(t, []byte{0x82, 0x0b, 0x00, 0x01, 0x00, 0x01, 'a', 0x02, 0x00, 0x02, 'b', '/', 0x02},
+			encode(t, func() ([]byte, error) { return encodeSubscribe(1, []string{"a", "b/"}, 2) }))
+		assert.Equal(t, []byte{0xa2, 0x05, 0x00, 0x01, 0x00, 0x01, 'a'},
+			encode(t, func() ([]byte, error) { return encodeUnsubscribe(1, []string{"a"}) }))
+		assert.Equal(t, []byte{0xe0, 0x00}, encodeEmpty(packetDisconnect))
+
+		long := []string{string(make([]byte, maxStringLength+1))}
+		_, err := encodeSubscribe(1, long, 0)
+		assert.ErrorIs(t, err, errStringTooLong)
+		_, err = encodeUnsubscribe(1, long)
+		assert.ErrorIs(t, err, errStringTooLong)
+	})
+}
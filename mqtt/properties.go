@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrProtocolV5Required is returned when MQTT v5 features are used on a connection
+// that was not created with WithProtocolV5.
+var ErrProtocolV5Required = errors.New("MQTT v5 features require a connection using WithProtocolV5")
+
+// A UserProperty is a key/value pair attached to an MQTT v5 message. Keys may repeat.
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// Properties are the MQTT v5 properties of a message
+type Properties struct {
+	// ContentType describes the payload, e.g. a MIME type
+	ContentType string
+	// ResponseTopic and CorrelationData are used for request/response patterns
+	ResponseTopic   string
+	CorrelationData []byte
+	// MessageExpiry is the time after which the broker discards an undelivered
+	// message, rounded up to full seconds. 0 means no expiry.
+	MessageExpiry time.Duration
+	// UserProperties are application defined properties, in order of appearance
+	UserProperties []UserProperty
+}
+
+// UserProperty returns the value of the first user property with the given key
+func (props *Properties) UserProperty(key string) (string, bool) {
+	if props == nil {
+		return "", false
+	}
+	for _, prop := range props.UserProperties {
+		if prop.Key == key {
+			return prop.Value, true
+		}
+	}
+	return "", false
+}
+
+func (props *Properties) validate() error {
+	if len(props.CorrelationData) > math.MaxUint16 {
+		return fmt.Errorf("correlation data exceeds %d bytes", math.MaxUint16)
+	}
+	if props.MessageExpiry < 0 || props.MessageExpiry > math.MaxUint32*time.Second {
+		return fmt.Errorf("invalid message expiry %v", props.MessageExpiry)
+	}
+	return nil
+}
+
+// messageExpirySeconds returns the message expiry interval as sent to the broker
+func (props *Properties) messageExpirySeconds() uint32 {
+	return uint32((props.MessageExpiry + time.Second - 1) / time.Second)
+}
+
+// A Message is a message received for a subscribed topic
+type Message struct {
+	Topic   string
+	Payload []byte
+	// Properties are only set for messages received via MQTT v5 that carry properties
+	Properties *Properties
+
+	// QoS is the QoS level the message was delivered with
+	QoS byte
+	// Retain tells whether the broker sent the message as the retained message of the
+	// topic on subscription, rather than as a live update
+	Retain bool
+	// Duplicate tells whether the broker may have delivered the message before. It is
+	// always false with the libmosquitto backend, which does not report it.
+	Duplicate bool
+	// MessageID is the packet identifier of the message, 0 for QoS 0
+	MessageID int
+
+	// subscriptionIDs are the MQTT v5 subscription identifiers sent by the broker
+	subscriptionIDs []int
+}
+
+// A MessageCallback is a function run for every message received for a topic
+// subscribed with SubscribeMessage
+type MessageCallback func(message *Message)
+
+// RetainHandling controls when the broker sends retained messages for a new subscription
+type RetainHandling byte
+
+const (
+	// SendRetainAlways sends retained messages on every subscribe (default)
+	SendRetainAlways RetainHandling = iota
+	// SendRetainNew only sends retained messages if the subscription did not exist before
+	SendRetainNew
+	// SendRetainNever does not send retained messages on subscribe
+	SendRetainNever
+)
+
+// A SubscribeOption configures a subscription
+type SubscribeOption func(*SubscribeOptions)
+
+// SubscribeOptions are the settings of a subscription, as configured by SubscribeOption values
+type SubscribeOptions struct {
+	// QoS is the maximum QoS of the messages sent by the broker, 2 by default
+	QoS               byte
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    RetainHandling
+}
+
+// isV5 returns whether the options require an MQTT v5 connection
+func (opts SubscribeOptions) isV5() bool {
+	return opts.NoLocal || opts.RetainAsPublished || opts.RetainHandling != SendRetainAlways
+}
+
+// WithQoS sets the maximum QoS of the messages sent by the broker for the subscription.
+// Messages published with a higher QoS are downgraded by the broker.
+func WithQoS(qos byte) SubscribeOption {
+	return func(opts *SubscribeOptions) {
+		opts.QoS = qos
+	}
+}
+
+// WithNoLocal prevents the broker from sending messages published by this client
+// back to it (MQTT v5 only)
+func WithNoLocal() SubscribeOption {
+	return func(opts *SubscribeOptions) {
+		opts.NoLocal = true
+	}
+}
+
+// WithRetainAsPublished keeps the retain flag of forwarded messages as set by their
+// publisher, instead of clearing it for messages that are not initial values (MQTT v5 only)
+func WithRetainAsPublished() SubscribeOption {
+	return func(opts *SubscribeOptions) {
+		opts.RetainAsPublished = true
+	}
+}
+
+// WithRetainHandling controls when the broker sends retained messages (MQTT v5 only)
+func WithRetainHandling(retainHandling RetainHandling) SubscribeOption {
+	return func(opts *SubscribeOptions) {
+		opts.RetainHandling = retainHandling
+	}
+}
+
+// bits returns the subscription options as encoded in the SUBSCRIBE packet (without QoS)
+func (opts SubscribeOptions) bits() byte {
+	var bits byte
+	if opts.NoLocal {
+		bits |= 0x04
+	}
+	if opts.RetainAsPublished {
+		bits |= 0x08
+	}
+	return bits | byte(opts.RetainHandling)<<4
+}
+
+// NewSubscribeOptions applies opts to the default SubscribeOptions and validates the result
+func NewSubscribeOptions(opts ...SubscribeOption) (SubscribeOptions, error) {
+	subOpts := SubscribeOptions{QoS: 2}
+	for _, opt := range opts {
+		opt(&subOpts)
+	}
+	if subOpts.QoS > 2 {
+		return subOpts, fmt.Errorf("invalid QoS %d", subOpts.QoS)
+	}
+	if subOpts.RetainHandling > SendRetainNever {
+		return subOpts, fmt.Errorf("invalid retain handling %d", subOpts.RetainHandling)
+	}
+	return subOpts, nil
+}
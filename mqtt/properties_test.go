@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProperties(t *testing.T) {
+	props := &Properties{
+		MessageExpiry: 1500 * time.Millisecond,
+		UserProperties: []UserProperty{
+			{Key: "unit", Value: "Wh"},
+			{Key: "unit", Value: "kWh"},
+		},
+	}
+	assert.NoError(t, props.validate())
+	assert.Equal(t, uint32(2), props.messageExpirySeconds())
+
+	value, ok := props.UserProperty("unit")
+	assert.True(t, ok)
+	assert.Equal(t, "Wh", value)
+	_, ok = props.UserProperty("scale")
+	assert.False(t, ok)
+
+	_, ok = (*Properties)(nil).UserProperty("unit")
+	assert.False(t, ok)
+
+	props.CorrelationData = make([]byte, 1<<16)
+	assert.Error(t, props.validate())
+}
+
+func TestSubscribeOptions(t *testing.T) {
+	opts, err := NewSubscribeOptions()
+	assert.NoError(t, err)
+	assert.False(t, opts.isV5())
+	assert.Equal(t, byte(0), opts.bits())
+	assert.Equal(t, byte(2), opts.QoS)
+
+	opts, err = NewSubscribeOptions(WithQoS(0))
+	assert.NoError(t, err)
+	assert.False(t, opts.isV5())
+	assert.Equal(t, byte(0), opts.QoS)
+
+	opts, err = NewSubscribeOptions(WithNoLocal(), WithRetainAsPublished(), WithRetainHandling(SendRetainNever))
+	assert.NoError(t, err)
+	assert.True(t, opts.isV5())
+	assert.Equal(t, byte(0x2c), opts.bits())
+
+	_, err = NewSubscribeOptions(WithRetainHandling(3))
+	assert.Error(t, err)
+	_, err = NewSubscribeOptions(WithQoS(3))
+	assert.Error(t, err)
+}
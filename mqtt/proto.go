@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tq-systems/public-go-utils/v3/log"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// A ProtoMessage is a pointer to a protobuf message type T, as required by SubscribeProto
+type ProtoMessage[T any] interface {
+	*T
+	proto.Message
+}
+
+// marshalProto marshals message, using the faster MarshalVT if available
+func marshalProto(message proto.Message) ([]byte, error) {
+	type vtProtoMessage interface{ MarshalVT() ([]byte, error) }
+	if vtmessage, ok := message.(vtProtoMessage); ok {
+		return vtmessage.MarshalVT()
+	}
+	return proto.Marshal(message)
+}
+
+// unmarshalProto unmarshals data into message, using the faster UnmarshalVT if available
+func unmarshalProto(data []byte, message proto.Message) error {
+	type vtProtoMessage interface{ UnmarshalVT([]byte) error }
+	if vtmessage, ok := message.(vtProtoMessage); ok {
+		return vtmessage.UnmarshalVT(data)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+/* SubscribeProto subscribes to topic like Client.Subscribe, but unmarshals every
+ * message into a new T before running callback. Messages that cannot be
+ * unmarshalled are reported to errCallback instead; if errCallback is nil, they
+ * are logged. The type of the message has to be passed explicitly:
+ *
+ *	mqtt.SubscribeProto[pb.Reading](client, topic, func(topic string, reading *pb.Reading) {
+ *		...
+ *	}, nil)
+ */
+func SubscribeProto[T any, PT ProtoMessage[T]](client Client, topic string, callback func(topic string, message PT),
+	errCallback func(topic string, err error), opts ...SubscribeOption) (Subscription, error) {
+	if callback == nil {
+		return nil, errors.New("error during Subscription: nil callback not allowed")
+	}
+
+	return client.Subscribe(topic, func(topic string, data []byte) {
+		message := PT(new(T))
+		if err := unmarshalProto(data, message); err != nil {
+			err = fmt.Errorf("unable to unmarshal %s: %w", message.ProtoReflect().Descriptor().FullName(), err)
+			if errCallback != nil {
+				errCallback(topic, err)
+			} else {
+				log.Errorf("Dropping message on topic %s: %v", topic, err)
+			}
+			return
+		}
+		callback(topic, message)
+	}, opts...)
+}
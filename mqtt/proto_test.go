@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	mock_mqtt "github.com/tq-systems/public-go-utils/v3/mocks/mqtt"
+	"github.com/tq-systems/public-go-utils/v3/mqtt"
+	"github.com/tq-systems/public-go-utils/v3/mqtt/test"
+)
+
+func TestSubscribeProto(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := mock_mqtt.NewMockClient(ctrl)
+	subscription := mock_mqtt.NewMockSubscription(ctrl)
+
+	var callback mqtt.Callback
+	client.EXPECT().Subscribe("meter/+", gomock.Any()).DoAndReturn(
+		func(topic string, cb mqtt.Callback, _ ...mqtt.SubscribeOption) (mqtt.Subscription, error) {
+			callback = cb
+			return subscription, nil
+		})
+
+	received := make([]*test.Test, 0)
+	errs := make([]error, 0)
+	sub, err := mqtt.SubscribeProto[test.Test](client, "meter/+", func(topic string, message *test.Test) {
+		assert.Equal(t, "meter/1", topic)
+		received = append(received, message)
+	}, func(topic string, err error) {
+		errs = append(errs, err)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, subscription, sub)
+
+	// messageCounter = 42
+	callback("meter/1", []byte{0x08, 0x2a})
+	// Truncated varint
+	callback("meter/1", []byte{0x08})
+
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, uint64(42), received[0].MessageCounter)
+	}
+	assert.Len(t, errs, 1)
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/* A RecordedMessage is a message captured by Record. In a recording, each message is
+ * stored on one line as the time in RFC 3339 format with nanoseconds, the QoS, "r" for
+ * retained messages or "-" otherwise, the quoted topic and the base64 encoded payload,
+ * separated by spaces:
+ *
+ *	2026-10-18T10:00:00.5Z 1 - "meters/1/power" MTIzNA==
+ */
+type RecordedMessage struct {
+	Time    time.Time
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// MarshalText returns the message in the line format of recordings, without newline
+func (m RecordedMessage) MarshalText() ([]byte, error) {
+	retain := "-"
+	if m.Retain {
+		retain = "r"
+	}
+	line := fmt.Sprintf("%s %d %s %s %s", m.Time.UTC().Format(time.RFC3339Nano), m.QoS, retain,
+		strconv.Quote(m.Topic), base64.StdEncoding.EncodeToString(m.Payload))
+	return []byte(line), nil
+}
+
+// UnmarshalText parses a line of a recording
+func (m *RecordedMessage) UnmarshalText(text []byte) error {
+	fields := bytes.SplitN(text, []byte(" "), 3)
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid recorded message %q", text)
+	}
+	recordTime, err := time.Parse(time.RFC3339Nano, string(fields[0]))
+	if err != nil {
+		return fmt.Errorf("invalid time of recorded message: %w", err)
+	}
+	qos, err := strconv.ParseUint(string(fields[1]), 10, 8)
+	if err != nil || qos > 2 {
+		return fmt.Errorf("invalid QoS of recorded message %q", fields[1])
+	}
+
+	rest := string(fields[2])
+	if len(rest) < 2 || (rest[0] != 'r' && rest[0] != '-') || rest[1] != ' ' {
+		return fmt.Errorf("invalid retain flag of recorded message %q", text)
+	}
+	retain := rest[0] == 'r'
+	quotedTopic, err := strconv.QuotedPrefix(rest[2:])
+	if err != nil {
+		return fmt.Errorf("invalid topic of recorded message: %w", err)
+	}
+	topic, _ := strconv.Unquote(quotedTopic)
+	encodedPayload, ok := bytes.CutPrefix([]byte(rest[2+len(quotedTopic):]), []byte(" "))
+	if !ok {
+		return fmt.Errorf("missing payload of recorded message %q", text)
+	}
+	payload, err := base64.StdEncoding.DecodeString(string(encodedPayload))
+	if err != nil {
+		return fmt.Errorf("invalid payload of recorded message: %w", err)
+	}
+
+	*m = RecordedMessage{Time: recordTime, Topic: topic, Payload: payload, QoS: byte(qos), Retain: retain}
+	return nil
+}
+
+/* Record subscribes to filters with opts and writes every message received to w, one
+ * line per message (see RecordedMessage), until ctx is done. It returns nil then, or the
+ * first error subscribing or writing. Use WithQoS to keep the QoS of the messages, which
+ * the broker lowers to the QoS of the subscription.
+ *
+ * Messages matching several filters are recorded once: only the subscription of the
+ * first filter matching the topic records them.
+ */
+func Record(ctx context.Context, client Client, w io.Writer, filters []string, opts ...SubscribeOption) error {
+	var lock sync.Mutex
+	// stopped is set once the subscriptions are removed, for callbacks still running
+	stopped := false
+	writeErr := make(chan error, 1)
+	record := func(filter int, message *Message) {
+		if slices.IndexFunc(filters, func(f string) bool { return TopicMatches(f, message.Topic) }) != filter {
+			return
+		}
+		line, _ := RecordedMessage{
+			Time:    time.Now(),
+			Topic:   message.Topic,
+			Payload: message.Payload,
+			QoS:     message.QoS,
+			Retain:  message.Retain,
+		}.MarshalText()
+
+		lock.Lock()
+		defer lock.Unlock()
+		if stopped {
+			return
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			select {
+			case writeErr <- fmt.Errorf("failed to record message: %w", err):
+			default:
+			}
+		}
+	}
+
+	requests := make([]SubscriptionRequest, len(filters))
+	for i, filter := range filters {
+		callback := func(message *Message) {
+			record(i, message)
+		}
+		requests[i] = SubscriptionRequest{Topic: filter, Callback: callback, Options: opts}
+	}
+	subs, err := client.SubscribeMany(ctx, requests)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.UnsubscribeMany(context.Background(), subs)
+		locked(&lock, func() {
+			stopped = true
+		})
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-writeErr:
+		return err
+	}
+}
+
+/* Replay publishes the messages recorded in r with PublishRawContext, keeping the
+ * original intervals between them divided by speed, e.g. 2 replays twice as fast. With
+ * speed 0, the messages are published without delay. Replay stops at the first error or
+ * when ctx is done.
+ */
+func Replay(ctx context.Context, client Client, r io.Reader, speed float64) error {
+	if speed < 0 {
+		return fmt.Errorf("invalid replay speed %v", speed)
+	}
+
+	scanner := bufio.NewScanner(r)
+	// Lines grow with the payload, which is limited by MQTT to 256 MiB
+	scanner.Buffer(nil, 512*1024*1024)
+
+	var first time.Time
+	start := time.Now()
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var message RecordedMessage
+		if err := message.UnmarshalText(scanner.Bytes()); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if first.IsZero() {
+			first = message.Time
+		}
+		if speed > 0 {
+			offset := time.Duration(float64(message.Time.Sub(first)) / speed)
+			if err := sleepUntil(ctx, start.Add(offset)); err != nil {
+				return err
+			}
+		}
+
+		if err := client.PublishRawContext(ctx, message.Topic, message.QoS, message.Retain, message.Payload); err != nil {
+			return fmt.Errorf("failed to replay message of line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// sleepUntil waits until t or until ctx is done, returning the error of ctx then
+func sleepUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tq-systems/public-go-utils/v3/mqtt"
+	"github.com/tq-systems/public-go-utils/v3/mqtt/mqtttest"
+)
+
+// lineWriter passes every write to a channel
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestRecordedMessage(t *testing.T) {
+	message := mqtt.RecordedMessage{
+		Time:    time.Date(2026, 10, 18, 10, 0, 0, 500000000, time.UTC),
+		Topic:   "meters/a \"b\"",
+		Payload: []byte("1234"),
+		QoS:     1,
+		Retain:  true,
+	}
+	text, err := message.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, `2026-10-18T10:00:00.5Z 1 r "meters/a \"b\"" MTIzNA==`, string(text))
+
+	var parsed mqtt.RecordedMessage
+	assert.NoError(t, parsed.UnmarshalText(text))
+	assert.Equal(t, message, parsed)
+
+	empty := mqtt.RecordedMessage{Time: message.Time, Topic: "a", Payload: []byte{}}
+	text, _ = empty.MarshalText()
+	assert.Equal(t, `2026-10-18T10:00:00.5Z 0 - "a" `, string(text))
+	assert.NoError(t, parsed.UnmarshalText(text))
+	assert.Equal(t, empty, parsed)
+
+	for _, line := range []string{
+		"",
+		`yesterday 0 - "a" MTIzNA==`,
+		`2026-10-18T10:00:00Z 3 - "a" MTIzNA==`,
+		`2026-10-18T10:00:00Z 0 x "a" MTIzNA==`,
+		`2026-10-18T10:00:00Z 0 - a MTIzNA==`,
+		`2026-10-18T10:00:00Z 0 - "a"`,
+		`2026-10-18T10:00:00Z 0 - "a" ???`,
+	} {
+		assert.Error(t, parsed.UnmarshalText([]byte(line)), line)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	broker := mqtttest.NewBroker()
+	client := broker.NewClient("client")
+	defer client.Close()
+
+	assert.NoError(t, client.PublishRaw("config/a", 1, true, []byte("retained")))
+
+	lines := make(lineWriter, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- mqtt.Record(ctx, client, lines, []string{"config/+", "meters/#", "meters/+"}, mqtt.WithQoS(2))
+	}()
+
+	// The retained message arrives once subscribed
+	assert.Contains(t, <-lines, ` 1 r "config/a" cmV0YWluZWQ=`)
+	assert.NoError(t, client.PublishRaw("meters/1", 0, false, []byte("1")))
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, client.PublishRaw("meters/2", 1, false, []byte("2")))
+	assert.NoError(t, client.PublishRaw("other", 1, false, []byte("3")))
+	cancel()
+	assert.NoError(t, <-done)
+	close(lines)
+
+	var recording bytes.Buffer
+	for line := range lines {
+		recording.WriteString(line)
+	}
+	// Messages matching several filters are recorded once
+	assert.Equal(t, 2, strings.Count(recording.String(), "\n"))
+
+	// Replay the live messages twice as fast
+	replayed := make(chan *mqtt.Message, 10)
+	_, err := client.SubscribeMessage(context.Background(), "meters/#", func(message *mqtt.Message) {
+		replayed <- message
+	}, mqtt.WithQoS(2))
+	assert.NoError(t, err)
+
+	start := time.Now()
+	assert.NoError(t, mqtt.Replay(context.Background(), client, &recording, 2))
+	assert.GreaterOrEqual(t, time.Since(start), 25*time.Millisecond)
+	assert.Equal(t, "meters/1", (<-replayed).Topic)
+	second := <-replayed
+	assert.Equal(t, "meters/2", second.Topic)
+	assert.Equal(t, []byte("2"), second.Payload)
+	assert.Equal(t, byte(1), second.QoS)
+
+	err = mqtt.Replay(context.Background(), client, strings.NewReader("\ninvalid\n"), 0)
+	assert.ErrorContains(t, err, "line 2")
+	assert.Error(t, mqtt.Replay(context.Background(), client, strings.NewReader(""), -1))
+
+	// Replay stops when ctx is done
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	slow := `2026-10-18T10:00:00Z 0 - "meters/1" MQ==
+2026-10-18T11:00:00Z 0 - "meters/1" MQ==
+`
+	assert.ErrorIs(t, mqtt.Replay(ctx, client, strings.NewReader(slow), 1), context.DeadlineExceeded)
+}
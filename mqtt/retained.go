@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoRetained is returned by GetRetained if the broker has no retained message for the topic
+	ErrNoRetained = errors.New("no retained MQTT message")
+	// retainedWait is how long to wait for further retained messages from the broker
+	retainedWait = 100 * time.Millisecond
+)
+
+/* GetRetained returns the retained message of topic. The topic is subscribed until the
+ * broker sent its retained message, or until no message arrived for a short time after
+ * the broker confirmed the subscription, which returns ErrNoRetained. While the client is
+ * disconnected, an error is returned instead.
+ *
+ * As the broker sends the retained message in response to the subscription, existing
+ * subscriptions matching topic receive it again.
+ */
+func (client *client) GetRetained(ctx context.Context, topic string) (*Message, error) {
+	if !ValidTopicName(topic) {
+		return nil, errors.New("failed to get retained message: invalid topic name")
+	}
+	messages, err := client.collectRetained(ctx, topic, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, ErrNoRetained
+	}
+	return messages[0], nil
+}
+
+/* GetRetainedPattern returns the retained messages of all topics matching filter, sorted
+ * by topic. The filter is subscribed until no further retained message arrived for a
+ * short time. Like with GetRetained, existing subscriptions matching filter receive the
+ * retained messages again.
+ */
+func (client *client) GetRetainedPattern(ctx context.Context, filter string) ([]*Message, error) {
+	if !ValidTopicFilter(filter) {
+		return nil, errors.New("failed to get retained messages: invalid topic filter")
+	}
+	if _, _, shared := splitSharedFilter(filter); shared {
+		// brokers do not send retained messages to shared subscriptions
+		return nil, errors.New("failed to get retained messages: shared subscription filter")
+	}
+	return client.collectRetained(ctx, filter, false)
+}
+
+// ClearRetained removes the retained messages of all topics matching filter
+func (client *client) ClearRetained(ctx context.Context, filter string) error {
+	messages, err := client.GetRetainedPattern(ctx, filter)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		if err := client.PublishRawContext(ctx, message.Topic, 1, true, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* collectRetained subscribes filter and collects the retained messages sent by the
+ * broker. A SUBSCRIBE is sent even if filter is subscribed already, as the broker only
+ * sends retained messages in response to one, so existing subscriptions of filter
+ * receive them again. The SUBSCRIBE keeps the QoS of an existing broker subscription of
+ * filter. With first set, it returns as soon as a retained message arrived.
+ */
+func (client *client) collectRetained(ctx context.Context, filter string, first bool) ([]*Message, error) {
+	var lock sync.Mutex
+	retained := make(map[string]*Message)
+	received := make(chan struct{}, 1)
+	collect := func(message *Message) {
+		if !message.Retain {
+			return
+		}
+		locked(&lock, func() {
+			retained[message.Topic] = message
+		})
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+	}
+
+	opts, err := NewSubscribeOptions()
+	if err != nil {
+		return nil, err
+	}
+	subs, err := client.subscribe(ctx, []subscriptionRequest{
+		{topic: filter, callback: collect, opts: opts, resubscribe: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer subs[0].Unsubscribe()
+
+	timer := time.NewTimer(retainedWait)
+	defer timer.Stop()
+	for done := false; !done; {
+		select {
+		case <-received:
+			if first {
+				done = true
+			} else {
+				timer.Reset(retainedWait)
+			}
+		case <-timer.C:
+			done = true
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	messages := make([]*Message, 0)
+	locked(&lock, func() {
+		for _, message := range retained {
+			messages = append(messages, message)
+		}
+	})
+	slices.SortFunc(messages, func(a, b *Message) int {
+		return strings.Compare(a.Topic, b.Topic)
+	})
+	return messages, nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// messageTopics returns the topics of messages
+func messageTopics(messages []*Message) []string {
+	topics := make([]string, 0, len(messages))
+	for _, message := range messages {
+		topics = append(topics, message.Topic)
+	}
+	return topics
+}
+
+func TestRetainedMessages(t *testing.T) {
+	broker := startScriptBroker(t, "tcp", "127.0.0.1:0", 0)
+	client, err := NewClientWithOptions("127.0.0.1", broker.port(), "client", WithPureGo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, client.PublishRaw("config/b", 1, true, []byte("b")))
+	assert.Nil(t, client.PublishRaw("config/a", 1, true, []byte("a")))
+	assert.Nil(t, client.PublishRaw("config/live", 1, false, []byte("live")))
+
+	t.Run("Get", func(t *testing.T) {
+		message, err := client.GetRetained(ctx, "config/a")
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("a"), message.Payload)
+
+		_, err = client.GetRetained(ctx, "config/live")
+		assert.ErrorIs(t, err, ErrNoRetained)
+		_, err = client.GetRetained(ctx, "config/+")
+		assert.Error(t, err)
+
+		// The collecting subscription is removed again
+		assert.Empty(t, subscribedTopics(client))
+	})
+
+	t.Run("Pattern", func(t *testing.T) {
+		messages, err := client.GetRetainedPattern(ctx, "config/#")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"config/a", "config/b"}, messageTopics(messages))
+
+		messages, err = client.GetRetainedPattern(ctx, "other/#")
+		assert.Nil(t, err)
+		assert.Empty(t, messages)
+		_, err = client.GetRetainedPattern(ctx, "config/#/a")
+		assert.Error(t, err)
+	})
+
+	t.Run("Subscribed topic", func(t *testing.T) {
+		received := make(chan string, 10)
+		sub, err := client.Subscribe("config/b", func(_ string, message []byte) {
+			received <- string(message)
+		})
+		assert.Nil(t, err)
+		expectMessage(t, received, "b")
+
+		// The broker is asked to send the retained message again
+		message, err := client.GetRetained(ctx, "config/b")
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("b"), message.Payload)
+		assert.Equal(t, []string{"config/b"}, subscribedTopics(client))
+		// The existing subscription receives it again as well
+		expectMessage(t, received, "b")
+
+		assert.Nil(t, sub.UnsubscribeContext(ctx))
+	})
+
+	t.Run("Subscribed topic with QoS 0", func(t *testing.T) {
+		sub, err := client.Subscribe("config/a", func(string, []byte) {}, WithQoS(0))
+		assert.Nil(t, err)
+
+		// The broker subscription keeps its QoS
+		_, err = client.GetRetained(ctx, "config/a")
+		assert.Nil(t, err)
+		levels := broker.subscribedQoSLevels()
+		assert.Equal(t, []byte{0, 0}, levels[len(levels)-2:])
+
+		assert.Nil(t, sub.UnsubscribeContext(ctx))
+	})
+
+	t.Run("Clear", func(t *testing.T) {
+		assert.Nil(t, client.ClearRetained(ctx, "config/#"))
+		messages, err := client.GetRetainedPattern(ctx, "config/#")
+		assert.Nil(t, err)
+		assert.Empty(t, messages)
+	})
+
+	t.Run("Disconnected", func(t *testing.T) {
+		broker.stop()
+		assert.Eventually(t, func() bool {
+			return !client.IsConnected()
+		}, 5*time.Second, 10*time.Millisecond)
+
+		_, err := client.GetRetained(ctx, "config/a")
+		assert.ErrorIs(t, err, errNotConnected)
+		_, err = client.GetRetainedPattern(ctx, "config/#")
+		assert.ErrorIs(t, err, errNotConnected)
+		assert.Empty(t, subscribedTopics(client))
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		client.Close()
+		_, err := client.GetRetained(ctx, "config/a")
+		assert.ErrorIs(t, err, ErrClientClosed)
+	})
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* A Router runs handlers for messages of topics following templates like
+ * "meters/{serial}/obis/{code}", similar to the patterns of rest.Server. A level
+ * of the form {name} matches any value of the level, {name:pattern} only values
+ * matching the regular expression pattern. Every template is subscribed to with
+ * the named levels replaced by '+'; a message is passed to all templates it matches.
+ * Templates may be shared subscriptions "$share/<group>/<template>" (see SharedFilter).
+ */
+type Router struct {
+	client Client
+
+	lock          sync.Mutex
+	subscriptions map[string]Subscription
+	closed        bool
+}
+
+// TopicParams holds the values of the named levels of a template for a topic
+type TopicParams map[string]string
+
+// Int returns the value of the level name as an integer
+func (params TopicParams) Int(name string) (int, error) {
+	value, ok := params[name]
+	if !ok {
+		return 0, fmt.Errorf("no topic parameter '%s'", name)
+	}
+	return strconv.Atoi(value)
+}
+
+// A RouteHandler handles a message received for a template of a Router
+type RouteHandler func(message *Message, params TopicParams)
+
+// A topicTemplate is a parsed template of a Router
+type topicTemplate struct {
+	filter string
+	// params holds the parameter of every level, nil for the other levels
+	params []*topicParam
+}
+
+type topicParam struct {
+	name string
+	// pattern must match the whole level, nil matches any value
+	pattern *regexp.Regexp
+}
+
+var paramLevel = regexp.MustCompile(`^\{([A-Za-z_][A-Za-z0-9_]*)(?::(.+))?\}$`)
+
+func parseTemplate(template string) (*topicTemplate, error) {
+	// Received topics lack the "$share/<group>" prefix of shared templates
+	group, inner, shared := splitSharedFilter(template)
+	if strings.ContainsAny(group, "{}") {
+		return nil, fmt.Errorf("parameter in share group of topic template '%s'", template)
+	}
+	levels := strings.Split(inner, "/")
+	t := &topicTemplate{params: make([]*topicParam, len(levels))}
+	names := make(map[string]bool)
+
+	for i, level := range levels {
+		if !strings.ContainsAny(level, "{}") {
+			continue
+		}
+		match := paramLevel.FindStringSubmatch(level)
+		if match == nil {
+			return nil, fmt.Errorf("invalid level '%s' in topic template '%s'", level, template)
+		}
+		if names[match[1]] {
+			return nil, fmt.Errorf("duplicate parameter '%s' in topic template '%s'", match[1], template)
+		}
+		names[match[1]] = true
+
+		param := &topicParam{name: match[1]}
+		if match[2] != "" {
+			pattern, err := regexp.Compile("^(?:" + match[2] + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern of parameter '%s' in topic template '%s': %w",
+					match[1], template, err)
+			}
+			param.pattern = pattern
+		}
+		t.params[i] = param
+		levels[i] = "+"
+	}
+
+	t.filter = strings.Join(levels, "/")
+	if shared {
+		t.filter = SharedFilter(group, t.filter)
+	}
+	if !ValidTopicFilter(t.filter) {
+		return nil, fmt.Errorf("invalid topic template '%s'", template)
+	}
+	return t, nil
+}
+
+// match returns the parameters of topic, or false if a level does not match its pattern
+func (t *topicTemplate) match(topic string) (TopicParams, bool) {
+	levels := strings.Split(topic, "/")
+	params := make(TopicParams)
+	for i, param := range t.params {
+		if param == nil {
+			continue
+		}
+		// Parameters cannot follow '#', so the subscription guarantees the level exists
+		if param.pattern != nil && !param.pattern.MatchString(levels[i]) {
+			return nil, false
+		}
+		params[param.name] = levels[i]
+	}
+	return params, true
+}
+
+// NewRouter creates a Router subscribing with client
+func NewRouter(client Client) *Router {
+	return &Router{
+		client:        client,
+		subscriptions: make(map[string]Subscription),
+	}
+}
+
+// Handle subscribes to template, running handler for every message matching it
+func (router *Router) Handle(template string, handler RouteHandler, opts ...SubscribeOption) error {
+	if handler == nil {
+		return errors.New("nil route handler not allowed")
+	}
+	t, err := parseTemplate(template)
+	if err != nil {
+		return err
+	}
+
+	router.lock.Lock()
+	defer router.lock.Unlock()
+
+	if router.closed {
+		return errors.New("router closed")
+	}
+	if _, ok := router.subscriptions[template]; ok {
+		return fmt.Errorf("topic template %s is already handled", template)
+	}
+
+	sub, err := router.client.SubscribeMessage(context.Background(), t.filter, func(message *Message) {
+		if params, ok := t.match(message.Topic); ok {
+			handler(message, params)
+		}
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	router.subscriptions[template] = sub
+	return nil
+}
+
+// Close unsubscribes from all templates
+func (router *Router) Close() {
+	router.lock.Lock()
+	subscriptions := router.subscriptions
+	router.subscriptions = make(map[string]Subscription)
+	router.closed = true
+	router.lock.Unlock()
+
+	for _, sub := range subscriptions {
+		sub.Unsubscribe()
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tq-systems/public-go-utils/v3/mqtt"
+	"github.com/tq-systems/public-go-utils/v3/mqtt/mqtttest"
+)
+
+func TestRouter(t *testing.T) {
+	broker := mqtttest.NewBroker()
+	client := broker.NewClient("client")
+	defer client.Close()
+
+	router := mqtt.NewRouter(client)
+	defer router.Close()
+
+	received := make([]mqtt.TopicParams, 0)
+	handler := func(_ *mqtt.Message, params mqtt.TopicParams) {
+		received = append(received, params)
+	}
+
+	t.Run("Parameters", func(t *testing.T) {
+		assert.NoError(t, router.Handle("meters/{serial}/obis/{code}", handler))
+		assert.NoError(t, client.PublishEmpty("meters/1234/obis/1.8.0", 0, false))
+		assert.NoError(t, client.PublishEmpty("meters/1234/state", 0, false))
+		assert.Equal(t, []mqtt.TopicParams{{"serial": "1234", "code": "1.8.0"}}, received)
+
+		serial, err := received[0].Int("serial")
+		assert.NoError(t, err)
+		assert.Equal(t, 1234, serial)
+		_, err = received[0].Int("code")
+		assert.Error(t, err)
+		_, err = received[0].Int("unknown")
+		assert.Error(t, err)
+	})
+
+	t.Run("Patterns", func(t *testing.T) {
+		received = received[:0]
+		assert.NoError(t, router.Handle("inverters/{id:[0-9]+}/#", handler))
+		assert.NoError(t, client.PublishEmpty("inverters/7/power/ac", 0, false))
+		assert.NoError(t, client.PublishEmpty("inverters/main/power", 0, false))
+		assert.Equal(t, []mqtt.TopicParams{{"id": "7"}}, received)
+	})
+
+	t.Run("Shared templates", func(t *testing.T) {
+		received = received[:0]
+		assert.NoError(t, router.Handle("$share/group/batteries/{serial}/soc/{unit:%}", handler))
+		assert.NoError(t, client.PublishEmpty("batteries/12/soc/%", 0, false))
+		assert.NoError(t, client.PublishEmpty("batteries/12/soc/Wh", 0, false))
+		assert.Equal(t, []mqtt.TopicParams{{"serial": "12", "unit": "%"}}, received)
+	})
+
+	t.Run("Invalid templates", func(t *testing.T) {
+		for _, template := range []string{
+			"meters/{serial}x",
+			"meters/{serial}/{serial}",
+			"meters/{1serial}",
+			"meters/{serial:[}",
+			"meters/#/{serial}",
+			"$share/{group}/meters",
+			"$share//meters/{serial}",
+			"meters/{serial}/obis/{code}",
+		} {
+			assert.Error(t, router.Handle(template, handler), template)
+		}
+		assert.Error(t, router.Handle("meters/+", nil))
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		received = received[:0]
+		router.Close()
+		assert.NoError(t, client.PublishEmpty("meters/1/obis/1", 0, false))
+		assert.Empty(t, received)
+		assert.Error(t, router.Handle("other/{id}", handler))
+	})
+}
@@ -0,0 +1,395 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tq-systems/public-go-utils/v3/log"
+
+	"google.golang.org/protobuf/proto"
+)
+
+/* The RPC layer implements request/response calls on top of Publish and Subscribe,
+ * so it works with MQTT 3.1.1 and the mocks of this package. For a method topic M,
+ *
+ *	- requests are published to M/request/<requester ID>/<correlation ID>
+ *	- responses are published to M/response/<requester ID>/<correlation ID>
+ *
+ * Requests carry the marshalled request message. Responses start with a status
+ * byte, followed by the marshalled response message (rpcStatusOK) or an error
+ * message (rpcStatusError).
+ */
+
+const (
+	rpcRequestSegment  = "request"
+	rpcResponseSegment = "response"
+
+	rpcStatusOK    byte = 0
+	rpcStatusError byte = 1
+
+	rpcQoS = 1
+)
+
+var (
+	// ErrRPCTimedOut indicates that no response arrived within rpcTimeout. It is
+	// only returned if the caller did not set a deadline on the context of the call.
+	ErrRPCTimedOut = errors.New("waiting for the RPC response timed out")
+	// ErrRPCClosed is returned for calls that were still waiting when the RPCClient was closed
+	ErrRPCClosed = errors.New("RPC client closed")
+
+	rpcTimeout = 10 * time.Second
+)
+
+// An RPCError is an error returned by the handler of a remote method
+type RPCError struct {
+	Method  string
+	Message string
+}
+
+func (err *RPCError) Error() string {
+	return fmt.Sprintf("RPC %s failed: %s", err.Method, err.Message)
+}
+
+// An RPCHandler handles a request for a method and returns the response. The
+// context is cancelled when the RPCServer is closed. If the handler panics, the
+// caller receives an error response.
+type RPCHandler func(ctx context.Context, request []byte) (proto.Message, error)
+
+type rpcResponse struct {
+	payload []byte
+	err     error
+}
+
+// An RPCClient calls methods served by an RPCServer
+type RPCClient struct {
+	client      Client
+	requesterID string
+
+	// Serializes subscriptions to responses. It is separate from lock because
+	// responses must be handled while waiting for a subscription to be confirmed.
+	subscribeLock sync.Mutex
+
+	lock          sync.Mutex
+	subscriptions map[string]Subscription
+	pending       map[string]chan rpcResponse
+	closed        bool
+}
+
+// An RPCServer serves methods for RPCClients
+type RPCServer struct {
+	client Client
+
+	lock          sync.Mutex
+	subscriptions map[string]Subscription
+
+	// runningLock synchronizes starting handlers with Close
+	runningLock sync.Mutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+	running     sync.WaitGroup
+}
+
+// validTopicLevel returns whether s can be used as a single topic level
+func validTopicLevel(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "/+#")
+}
+
+// validMethod returns whether method can be used as a topic prefix
+func validMethod(method string) bool {
+	return method != "" && !strings.ContainsAny(method, "+#") && !strings.HasSuffix(method, "/")
+}
+
+// NewRPCClient creates an RPCClient using client. requesterID must be unique among
+// all RPC clients and must not contain '/', '+' or '#'; the MQTT client ID is a good choice.
+func NewRPCClient(client Client, requesterID string) (*RPCClient, error) {
+	if !validTopicLevel(requesterID) {
+		return nil, fmt.Errorf("invalid RPC requester ID '%s'", requesterID)
+	}
+
+	return &RPCClient{
+		client:        client,
+		requesterID:   requesterID,
+		subscriptions: make(map[string]Subscription),
+		pending:       make(map[string]chan rpcResponse),
+	}, nil
+}
+
+// subscribeResponses subscribes to the responses for method once
+func (rpc *RPCClient) subscribeResponses(ctx context.Context, method string) error {
+	rpc.subscribeLock.Lock()
+	defer rpc.subscribeLock.Unlock()
+
+	rpc.lock.Lock()
+	_, subscribed := rpc.subscriptions[method]
+	closed := rpc.closed
+	rpc.lock.Unlock()
+
+	if closed {
+		return ErrRPCClosed
+	}
+	if subscribed {
+		return nil
+	}
+
+	prefix := method + "/" + rpcResponseSegment + "/" + rpc.requesterID + "/"
+	sub, err := rpc.client.SubscribeContext(ctx, prefix+"+", func(topic string, message []byte) {
+		rpc.handleResponse(method, strings.TrimPrefix(topic, prefix), message)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to subscribe to RPC responses of %s: %w", method, err)
+	}
+
+	rpc.lock.Lock()
+	defer rpc.lock.Unlock()
+
+	if rpc.closed {
+		sub.Unsubscribe()
+		return ErrRPCClosed
+	}
+	rpc.subscriptions[method] = sub
+
+	return nil
+}
+
+func (rpc *RPCClient) handleResponse(method string, correlationID string, message []byte) {
+	var response rpcResponse
+	switch {
+	case len(message) > 0 && message[0] == rpcStatusOK:
+		response.payload = message[1:]
+	case len(message) > 0 && message[0] == rpcStatusError:
+		response.err = &RPCError{Method: method, Message: string(message[1:])}
+	default:
+		response.err = fmt.Errorf("malformed response for RPC %s", method)
+	}
+
+	rpc.lock.Lock()
+	defer rpc.lock.Unlock()
+
+	// Responses to calls that were given up already are ignored
+	if ch, ok := rpc.pending[correlationID]; ok {
+		ch <- response
+		delete(rpc.pending, correlationID)
+	}
+}
+
+/* Call calls method with request and unmarshals the result into response, which
+ * may be nil if the result is not of interest. Call waits for the response until
+ * ctx is done; if ctx has no deadline, waiting is limited to rpcTimeout and
+ * ErrRPCTimedOut is returned after it has passed. Errors returned by the remote
+ * handler are reported as *RPCError.
+ */
+func (rpc *RPCClient) Call(ctx context.Context, method string, request proto.Message, response proto.Message) error {
+	if !validMethod(method) {
+		return fmt.Errorf("invalid RPC method '%s'", method)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, rpcTimeout, ErrRPCTimedOut)
+		defer cancel()
+	}
+
+	if err := rpc.subscribeResponses(ctx, method); err != nil {
+		return err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	correlationID := hex.EncodeToString(id)
+
+	ch := make(chan rpcResponse, 1)
+	rpc.lock.Lock()
+	if rpc.closed {
+		rpc.lock.Unlock()
+		return ErrRPCClosed
+	}
+	rpc.pending[correlationID] = ch
+	rpc.lock.Unlock()
+
+	defer func() {
+		rpc.lock.Lock()
+		defer rpc.lock.Unlock()
+		delete(rpc.pending, correlationID)
+	}()
+
+	topic := method + "/" + rpcRequestSegment + "/" + rpc.requesterID + "/" + correlationID
+	if err := rpc.client.PublishContext(ctx, topic, rpcQoS, false, request); err != nil {
+		return fmt.Errorf("unable to publish RPC request for %s: %w", method, err)
+	}
+
+	select {
+	case result := <-ch:
+		if result.err != nil {
+			return result.err
+		}
+		if response == nil {
+			return nil
+		}
+		return unmarshalProto(result.payload, response)
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// Close unsubscribes from all responses. Calls still waiting return ErrRPCClosed.
+func (rpc *RPCClient) Close() {
+	rpc.lock.Lock()
+	rpc.closed = true
+	subscriptions := rpc.subscriptions
+	rpc.subscriptions = make(map[string]Subscription)
+	for correlationID, ch := range rpc.pending {
+		ch <- rpcResponse{err: ErrRPCClosed}
+		delete(rpc.pending, correlationID)
+	}
+	rpc.lock.Unlock()
+
+	for _, sub := range subscriptions {
+		sub.Unsubscribe()
+	}
+}
+
+// NewRPCServer creates an RPCServer using client
+func NewRPCServer(client Client) *RPCServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RPCServer{
+		client:        client,
+		subscriptions: make(map[string]Subscription),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Handle registers handler for method. Every request is handled in a goroutine of its own.
+func (server *RPCServer) Handle(method string, handler RPCHandler) error {
+	if !validMethod(method) {
+		return fmt.Errorf("invalid RPC method '%s'", method)
+	}
+	if handler == nil {
+		return errors.New("nil RPC handler not allowed")
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if server.ctx.Err() != nil {
+		return errors.New("RPC server closed")
+	}
+	if _, ok := server.subscriptions[method]; ok {
+		return fmt.Errorf("RPC method %s is already handled", method)
+	}
+
+	prefix := method + "/" + rpcRequestSegment + "/"
+	sub, err := server.client.Subscribe(prefix+"+/+", func(topic string, message []byte) {
+		ids := strings.Split(strings.TrimPrefix(topic, prefix), "/")
+		if len(ids) != 2 {
+			return
+		}
+
+		server.runningLock.Lock()
+		defer server.runningLock.Unlock()
+		if server.ctx.Err() != nil {
+			return
+		}
+
+		server.running.Add(1)
+		// The handler and publishing the response must not block the caller of this callback
+		go func() {
+			defer server.running.Done()
+			server.serve(method, handler, ids[0], ids[1], message)
+		}()
+	})
+	if err != nil {
+		return fmt.Errorf("unable to subscribe to RPC requests of %s: %w", method, err)
+	}
+	server.subscriptions[method] = sub
+
+	return nil
+}
+
+// HandleRPC registers a handler for method that receives the request unmarshalled into a new Req
+func HandleRPC[Req any, PReq ProtoMessage[Req]](server *RPCServer, method string,
+	handler func(ctx context.Context, request PReq) (proto.Message, error)) error {
+	if handler == nil {
+		return errors.New("nil RPC handler not allowed")
+	}
+
+	return server.Handle(method, func(ctx context.Context, data []byte) (proto.Message, error) {
+		request := PReq(new(Req))
+		if err := unmarshalProto(data, request); err != nil {
+			return nil, fmt.Errorf("invalid request: %w", err)
+		}
+		return handler(ctx, request)
+	})
+}
+
+func (server *RPCServer) serve(method string, handler RPCHandler, requesterID string, correlationID string,
+	request []byte) {
+	response, err := callHandler(server.ctx, method, handler, request)
+
+	var payload []byte
+	if err == nil && response != nil {
+		payload, err = marshalProto(response)
+	}
+	if err != nil {
+		payload = append([]byte{rpcStatusError}, err.Error()...)
+	} else {
+		payload = append([]byte{rpcStatusOK}, payload...)
+	}
+
+	topic := method + "/" + rpcResponseSegment + "/" + requesterID + "/" + correlationID
+	if err := server.client.PublishRawContext(server.ctx, topic, rpcQoS, false, payload); err != nil {
+		log.Errorf("Unable to publish response for RPC %s: %v", method, err)
+	}
+}
+
+// callHandler runs handler, turning a panic into an error response
+func callHandler(ctx context.Context, method string, handler RPCHandler, request []byte) (
+	response proto.Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("RPC handler of %s panicked: %v\n%s", method, r, debug.Stack())
+			response, err = nil, fmt.Errorf("RPC handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, request)
+}
+
+// Close unsubscribes from all requests, cancels the context of running handlers and
+// waits for them to return
+func (server *RPCServer) Close() {
+	server.runningLock.Lock()
+	server.cancel()
+	server.runningLock.Unlock()
+
+	server.lock.Lock()
+	subscriptions := server.subscriptions
+	server.subscriptions = make(map[string]Subscription)
+	server.lock.Unlock()
+
+	for _, sub := range subscriptions {
+		sub.Unsubscribe()
+	}
+
+	server.running.Wait()
+}
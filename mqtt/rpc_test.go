@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/protobuf/proto"
+
+	mock_mqtt "github.com/tq-systems/public-go-utils/v3/mocks/mqtt"
+	"github.com/tq-systems/public-go-utils/v3/mqtt"
+	"github.com/tq-systems/public-go-utils/v3/mqtt/test"
+)
+
+func TestRPC(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := mock_mqtt.NewMockClient(ctrl)
+	subscription := mock_mqtt.NewMockSubscription(ctrl)
+	subscription.EXPECT().Unsubscribe().Times(2)
+
+	// Requests and responses are passed between server and client by the mock
+	var requestCallback, responseCallback mqtt.Callback
+	client.EXPECT().Subscribe("meter/reset/request/+/+", gomock.Any()).DoAndReturn(
+		func(topic string, cb mqtt.Callback, _ ...mqtt.SubscribeOption) (mqtt.Subscription, error) {
+			requestCallback = cb
+			return subscription, nil
+		})
+	client.EXPECT().SubscribeContext(gomock.Any(), "meter/reset/response/app/+", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, topic string, cb mqtt.Callback, _ ...mqtt.SubscribeOption) (mqtt.Subscription, error) {
+			responseCallback = cb
+			return subscription, nil
+		})
+	client.EXPECT().PublishContext(gomock.Any(), gomock.Any(), byte(1), false, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, topic string, qos byte, retain bool, message proto.Message) error {
+			assert.True(t, strings.HasPrefix(topic, "meter/reset/request/app/"))
+			payload, err := proto.Marshal(message)
+			assert.NoError(t, err)
+			requestCallback(topic, payload)
+			return nil
+		}).Times(3)
+	client.EXPECT().PublishRawContext(gomock.Any(), gomock.Any(), byte(1), false, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, topic string, qos byte, retain bool, message []byte) error {
+			assert.True(t, strings.HasPrefix(topic, "meter/reset/response/app/"))
+			responseCallback(topic, message)
+			return nil
+		}).Times(3)
+
+	server := mqtt.NewRPCServer(client)
+	err := mqtt.HandleRPC(server, "meter/reset", func(ctx context.Context, request *test.Test) (proto.Message, error) {
+		if request.MessageCounter == 0 {
+			return nil, errors.New("counter must not be 0")
+		}
+		if request.MessageCounter == 13 {
+			panic("unlucky number")
+		}
+		return &test.Test{MessageCounter: request.MessageCounter + 1}, nil
+	})
+	assert.NoError(t, err)
+	defer server.Close()
+
+	rpc, err := mqtt.NewRPCClient(client, "app")
+	assert.NoError(t, err)
+	defer rpc.Close()
+
+	response := &test.Test{}
+	err = rpc.Call(context.Background(), "meter/reset", &test.Test{MessageCounter: 41}, response)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(42), response.MessageCounter)
+
+	err = rpc.Call(context.Background(), "meter/reset", &test.Test{}, response)
+	var rpcErr *mqtt.RPCError
+	if assert.ErrorAs(t, err, &rpcErr) {
+		assert.Equal(t, "counter must not be 0", rpcErr.Message)
+	}
+
+	// A panicking handler still sends a response
+	err = rpc.Call(context.Background(), "meter/reset", &test.Test{MessageCounter: 13}, response)
+	if assert.ErrorAs(t, err, &rpcErr) {
+		assert.Equal(t, "RPC handler panicked: unlucky number", rpcErr.Message)
+	}
+
+	_, err = mqtt.NewRPCClient(client, "app/1")
+	assert.Error(t, err)
+	err = rpc.Call(context.Background(), "meter/#", &test.Test{}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"strings"
+)
+
+// sharePrefix starts the topic filters of shared subscriptions
+const sharePrefix = "$share/"
+
+// SharedFilter returns the topic filter of a shared subscription to filter in
+// group. The broker delivers each message matching filter to only one of the
+// clients subscribed to the same group, e.g. to balance load between the
+// instances of a service.
+func SharedFilter(group string, filter string) string {
+	return sharePrefix + group + "/" + filter
+}
+
+// splitSharedFilter returns the group and the topic filter of a shared
+// subscription filter "$share/<group>/<filter>". ok is false if filter is not
+// a shared subscription filter.
+func splitSharedFilter(filter string) (group string, topicFilter string, ok bool) {
+	rest, ok := strings.CutPrefix(filter, sharePrefix)
+	if !ok {
+		return "", filter, false
+	}
+	group, topicFilter, _ = strings.Cut(rest, "/")
+	return group, topicFilter, true
+}
+
+// matchFilter returns the topic filter the topics of the messages received for
+// a subscription to filter match: the filter without the group prefix of
+// shared subscriptions.
+func matchFilter(filter string) string {
+	_, topicFilter, _ := splitSharedFilter(filter)
+	return topicFilter
+}
+
+// ValidTopicFilter returns whether filter is a valid subscription topic filter:
+// it must not be empty, '+' must occupy a whole topic level and '#' must be the
+// last topic level. Shared subscription filters "$share/<group>/<filter>" need
+// a group without wildcards and a valid filter.
+func ValidTopicFilter(filter string) bool {
+	if group, topicFilter, ok := splitSharedFilter(filter); ok {
+		if group == "" || strings.ContainsAny(group, "+#") {
+			return false
+		}
+		filter = topicFilter
+	}
+	if filter == "" {
+		return false
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidTopicName returns whether topic is a valid topic to publish to: it must
+// not be empty and must not contain wildcards.
+func ValidTopicName(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, "+#")
+}
+
+// TopicMatches returns whether topic matches the topic filter. Like in the
+// broker, topics starting with '$' are not matched by filters starting with
+// a wildcard. Shared subscription filters match the topics of their filter.
+func TopicMatches(filter string, topic string) bool {
+	if !ValidTopicFilter(filter) || !ValidTopicName(topic) {
+		return false
+	}
+	filter = matchFilter(filter)
+	if strings.HasPrefix(topic, "$") && (filter[0] == '+' || filter[0] == '#') {
+		return false
+	}
+
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			// "a/#" also matches "a"
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter  string
+		topic   string
+		matches bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/b", "a/b/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"+/+", "/b", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"#", "$SYS/broker", false},
+		{"+/broker", "$SYS/broker", false},
+		{"$SYS/#", "$SYS/broker", true},
+		{"a/#/b", "a/x/b", false},
+		{"a/b+", "a/b+", false},
+		{"a/b", "a/+", false},
+		{"$share/g/a/+", "a/b", true},
+		{"$share/g/#", "a/b", true},
+		{"$share/g/a/+", "$share/g/a/b", false},
+		{"$share/g/#", "$SYS/broker", false},
+		{"$share//a", "a", false},
+		{"$share/g", "g", false},
+		{"$share/g+/a", "a", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.matches, TopicMatches(tt.filter, tt.topic), "filter %s, topic %s", tt.filter, tt.topic)
+	}
+
+	assert.Equal(t, "$share/g/a/#", SharedFilter("g", "a/#"))
+}
+
+func TestSubscriptionIDs(t *testing.T) {
+	client := &client{}
+	assert.Equal(t, 0, client.newSubscriptionID("a"))
+	assert.Equal(t, 0, client.newSubscriptionID(SharedFilter("g", "a")))
+
+	// With MQTT v5, every shared subscription gets an identifier of its own
+	client.protocolV5 = true
+	assert.Equal(t, nonSharedSubscriptionID, client.newSubscriptionID("a"))
+	assert.Equal(t, 2, client.newSubscriptionID(SharedFilter("g", "a")))
+	assert.Equal(t, 3, client.newSubscriptionID(SharedFilter("h", "a")))
+	assert.Equal(t, nonSharedSubscriptionID, client.newSubscriptionID("b/#"))
+
+	client.lastSubscriptionID = maxSubscriptionID
+	assert.Equal(t, 2, client.newSubscriptionID(SharedFilter("g", "b")))
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"strings"
+)
+
+/* topicTrie indexes values by topic filter, so the values of all filters matching a
+ * topic are found without comparing the topic to every filter. Each node is a level
+ * of the filters added, with '+' and '#' levels stored like any other level. It
+ * matches like TopicMatches and is not synchronized.
+ */
+type topicTrie[T comparable] struct {
+	root *trieNode[T]
+}
+
+type trieNode[T comparable] struct {
+	children map[string]*trieNode[T]
+	// values of the filter ending at this node
+	values map[T]struct{}
+}
+
+func newTopicTrie[T comparable]() *topicTrie[T] {
+	return &topicTrie[T]{root: &trieNode[T]{}}
+}
+
+// add adds value for filter
+func (trie *topicTrie[T]) add(filter string, value T) {
+	node := trie.root
+	for _, level := range strings.Split(filter, "/") {
+		child, ok := node.children[level]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode[T])
+			}
+			child = &trieNode[T]{}
+			node.children[level] = child
+		}
+		node = child
+	}
+	if node.values == nil {
+		node.values = make(map[T]struct{})
+	}
+	node.values[value] = struct{}{}
+}
+
+// remove removes value for filter and drops the nodes that are no longer needed
+func (trie *topicTrie[T]) remove(filter string, value T) {
+	trie.root.remove(strings.Split(filter, "/"), value)
+}
+
+// remove returns whether n is empty afterwards
+func (n *trieNode[T]) remove(levels []string, value T) bool {
+	if len(levels) == 0 {
+		delete(n.values, value)
+	} else if child, ok := n.children[levels[0]]; ok && child.remove(levels[1:], value) {
+		delete(n.children, levels[0])
+	}
+	return len(n.values) == 0 && len(n.children) == 0
+}
+
+// match runs fn for every value of the filters matching topic
+func (trie *topicTrie[T]) match(topic string, fn func(value T)) {
+	if !ValidTopicName(topic) {
+		return
+	}
+	// Topics starting with '$' are not matched by filters starting with a wildcard
+	trie.root.match(strings.Split(topic, "/"), strings.HasPrefix(topic, "$"), fn)
+}
+
+func (n *trieNode[T]) match(levels []string, noWildcard bool, fn func(value T)) {
+	if !noWildcard {
+		// "a/#" also matches "a"
+		if child, ok := n.children["#"]; ok {
+			child.each(fn)
+		}
+	}
+	if len(levels) == 0 {
+		n.each(fn)
+		return
+	}
+
+	if child, ok := n.children[levels[0]]; ok {
+		child.match(levels[1:], false, fn)
+	}
+	if !noWildcard {
+		if child, ok := n.children["+"]; ok {
+			child.match(levels[1:], false, fn)
+		}
+	}
+}
+
+func (n *trieNode[T]) each(fn func(value T)) {
+	for value := range n.values {
+		fn(value)
+	}
+}
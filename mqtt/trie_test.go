@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2026 TQ-Systems GmbH <license@tq-group.com>, D-82229
+ * Seefeld, Germany. All rights reserved.
+ * Author: Maximilian Eschenbacher and the Energy Manager development team
+ *
+ * This software is licensed under the TQ-Systems Product Software License
+ * Agreement Version 1.0.3 or any later version.
+ * You can obtain a copy of the License Agreement in the TQS (TQ-Systems
+ * Software Licenses) folder on the following website:
+ * https://www.tq-group.com/en/support/downloads/tq-software-license-conditions/
+ * In case of any license issues please contact license@tq-group.com.
+ */
+
+package mqtt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var trieFilters = []string{
+	"a/b", "a/+", "a/+/c", "+/+", "a/#", "#", "+/broker", "$SYS/#", "$SYS/+",
+	"a/#/b", "a/b+", "/b", "+", "a//c", "a/+/+",
+}
+
+var trieTopics = []string{
+	"a", "a/b", "a/c", "a/b/c", "/b", "a//c", "$SYS/broker", "$SYS", "b", "", "a/+", "x/y/z",
+}
+
+func TestTopicTrie(t *testing.T) {
+	trie := newTopicTrie[string]()
+	for _, filter := range trieFilters {
+		trie.add(filter, filter)
+	}
+
+	// The trie matches like TopicMatches
+	for _, topic := range trieTopics {
+		expected := make([]string, 0)
+		for _, filter := range trieFilters {
+			if TopicMatches(filter, topic) {
+				expected = append(expected, filter)
+			}
+		}
+		matched := make([]string, 0)
+		trie.match(topic, func(filter string) {
+			matched = append(matched, filter)
+		})
+		assert.ElementsMatch(t, expected, matched, "topic %s", topic)
+	}
+
+	// Several values per filter
+	trie.add("a/b", "other")
+	matched := make([]string, 0)
+	trie.match("a/b", func(value string) {
+		matched = append(matched, value)
+	})
+	assert.Contains(t, matched, "other")
+	assert.Contains(t, matched, "a/b")
+
+	// Removing all values prunes the nodes
+	trie.remove("a/b", "other")
+	trie.remove("a/b", "unknown")
+	trie.remove("x/y", "x/y")
+	for _, filter := range trieFilters {
+		trie.remove(filter, filter)
+	}
+	assert.Empty(t, trie.root.children)
+	assert.Empty(t, trie.root.values)
+}
+
+// benchmarkFilters returns n filters of the kind usually subscribed to
+func benchmarkFilters(n int) []string {
+	filters := make([]string, n)
+	for i := range filters {
+		switch i % 4 {
+		case 0:
+			filters[i] = fmt.Sprintf("device/%d/state", i)
+		case 1:
+			filters[i] = fmt.Sprintf("device/%d/+", i)
+		case 2:
+			filters[i] = fmt.Sprintf("rpc/%d/#", i)
+		default:
+			filters[i] = fmt.Sprintf("+/%d/config", i)
+		}
+	}
+	return filters
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		filters := benchmarkFilters(n)
+		topic := fmt.Sprintf("device/%d/state", n/2)
+
+		// Matching every subscription, for comparison with the trie
+		b.Run(fmt.Sprintf("Linear/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				matched := 0
+				for _, filter := range filters {
+					if TopicMatches(filter, topic) {
+						matched++
+					}
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("Trie/%d", n), func(b *testing.B) {
+			trie := newTopicTrie[string]()
+			for _, filter := range filters {
+				trie.add(filter, filter)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				matched := 0
+				trie.match(topic, func(string) {
+					matched++
+				})
+			}
+		})
+	}
+}
@@ -14,8 +14,10 @@
 package rest
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/tq-systems/public-go-utils/v3/log"
@@ -84,3 +86,26 @@ func NewErrorResponse(status int, msg string, code *int, details any) *Response
 		},
 	})
 }
+
+// A MetricsWriter writes metrics in the Prometheus text exposition format,
+// like mqtt.Metrics
+type MetricsWriter interface {
+	WritePrometheus(w io.Writer) error
+}
+
+// NewPrometheusResponse returns the metrics of all writers in the Prometheus
+// text exposition format. The writers must not write metrics of the same name.
+func NewPrometheusResponse(writers ...MetricsWriter) *Response {
+	var buf bytes.Buffer
+	for _, writer := range writers {
+		if err := writer.WritePrometheus(&buf); err != nil {
+			return InternalError(fmt.Errorf("unable to write metrics: %v", err))
+		}
+	}
+
+	return &Response{
+		"text/plain; version=0.0.4; charset=utf-8",
+		http.StatusOK,
+		buf.Bytes(),
+	}
+}